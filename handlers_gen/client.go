@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// ClientData is passed to clientTpl to render the client package.
+type ClientData struct {
+	PackageName string
+	Imports     []ImportSpec
+	Types       []string
+	Clients     []ClientApi
+	AuthHeader  string
+}
+
+// ClientApi is the client of a single API struct.
+type ClientApi struct {
+	Name    string
+	Methods []ClientMethod
+}
+
+// ClientMethod is a client method calling one API method.
+type ClientMethod struct {
+	Name       string
+	HTTPMethod string
+	Url        string
+	// ParamsType and ResultType are empty if the method takes no params or
+	// returns only an error
+	ParamsType string
+	ResultType string
+	Auth       bool
+	InBody     bool
+	// Encode are the statements putting the params fields into the url
+	// (path) and the url.Values (params) of the request
+	Encode []string
+}
+
+var clientTpl = template.Must(template.New("clientTpl").Parse(`// Code generated by handlers_gen. DO NOT EDIT.
+
+package {{.PackageName}}
+
+import (
+{{range .Imports}}	{{if .Name}}{{.Name}} {{end}}"{{.Path}}"
+{{end}})
+
+// ApiError is returned by the client when the API responds with an error.
+// Fields lists the invalid params when the API reports them one by one.
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+	Fields     []FieldError
+}
+
+// FieldError is the error of a single invalid param.
+type FieldError struct {
+	Param   string ` + "`json:\"param\"`" + `
+	Rule    string ` + "`json:\"rule\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+{{range .Types}}{{.}}
+
+{{end}}
+{{range $api := .Clients}}
+// {{.Name}}Client calls the methods of {{.Name}} over HTTP.
+type {{.Name}}Client struct {
+	BaseURL    string
+	AuthToken  string
+	HTTPClient *http.Client
+}
+
+func New{{.Name}}Client(baseURL string, authToken string) *{{.Name}}Client {
+	return &{{.Name}}Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		AuthToken:  authToken,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+func (c *{{.Name}}Client) do(req *http.Request, auth bool, res interface{}) error {
+	if auth {
+		req.Header.Set("{{$.AuthHeader}}", c.AuthToken)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Error    string          ` + "`json:\"error\"`" + `
+		Response json.RawMessage ` + "`json:\"response\"`" + `
+		Fields   []FieldError    ` + "`json:\"fields\"`" + `
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return ApiError{HTTPStatus: resp.StatusCode, Err: fmt.Errorf("bad response: %w", err)}
+	}
+	if envelope.Error != "" || resp.StatusCode != http.StatusOK {
+		return ApiError{HTTPStatus: resp.StatusCode, Err: errors.New(envelope.Error), Fields: envelope.Fields}
+	}
+	if res == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Response, res)
+}
+{{range .Methods}}
+func (c *{{$api.Name}}Client) {{.Name}}(ctx context.Context{{if .ParamsType}}, in {{.ParamsType}}{{end}}) {{if .ResultType}}({{.ResultType}}, error){{else}}error{{end}} {
+	path := "{{.Url}}"
+	params := url.Values{}
+{{range .Encode}}	{{.}}
+{{end}}
+{{if .InBody}}	req, err := http.NewRequestWithContext(ctx, "{{.HTTPMethod}}", c.BaseURL+path, strings.NewReader(params.Encode()))
+	if err != nil {
+		return {{if .ResultType}}nil, {{end}}err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+{{else}}	req, err := http.NewRequestWithContext(ctx, "{{.HTTPMethod}}", c.BaseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return {{if .ResultType}}nil, {{end}}err
+	}
+{{end}}{{if .ResultType}}
+	var res {{.ResultType}}
+	if err := c.do(req, {{.Auth}}, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+{{else}}
+	return c.do(req, {{.Auth}}, nil)
+{{end}}}
+{{end}}{{end}}`))
+
+// renderClient renders a Go client for every API struct of the package into
+// output. The params and result types are copied into the client package, so
+// that it does not depend on the API package.
+func renderClient(data PackageData, mapData map[string][]FuncData, output string, packageName string) (Output, error) {
+	if packageName == "" {
+		absOutput, _ := filepath.Abs(output)
+		packageName = filepath.Base(filepath.Dir(absOutput))
+	}
+
+	imports := newImportSet(data.Package, []string{"context", "encoding/json", "errors", "fmt", "net/http", "net/url", "strconv", "strings"})
+	copier := &typeCopier{pkg: data.Package, copied: make(map[string]string), imports: imports}
+	clientData := ClientData{
+		PackageName: packageName,
+		AuthHeader:  *authHeader,
+	}
+	for _, recvName := range sortedKeys(mapData) {
+		api := ClientApi{Name: recvName}
+		httpMethods := operationMethods(mapData[recvName])
+		for _, funcData := range mapData[recvName] {
+			api.Methods = append(api.Methods, clientMethod(funcData, httpMethods[funcData.MethodName][0], copier))
+		}
+		clientData.Clients = append(clientData.Clients, api)
+	}
+	for _, name := range copier.sortedNames() {
+		clientData.Types = append(clientData.Types, copier.copied[name])
+	}
+
+	body := new(bytes.Buffer)
+	if err := clientTpl.Execute(body, clientData); err != nil {
+		return Output{}, err
+	}
+	clientData.Imports = imports.used(body.Bytes())
+
+	body.Reset()
+	if err := clientTpl.Execute(body, clientData); err != nil {
+		return Output{}, err
+	}
+	source, err := format.Source(body.Bytes())
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Path: output, Content: source, Kind: "Client"}, nil
+}
+
+// clientMethod calls funcData with httpMethod, the first HTTP method
+// operationMethods documents it with.
+func clientMethod(funcData FuncData, httpMethod string, copier *typeCopier) ClientMethod {
+	paramsType, paramsStruct := paramsOf(funcData)
+	method := ClientMethod{
+		Name:       funcData.MethodName,
+		HTTPMethod: httpMethod,
+		Url:        funcData.Api.Url,
+		Auth:       funcData.Api.Auth,
+		InBody:     paramsInBody(httpMethod),
+	}
+	if paramsType != nil {
+		copier.copyNamed(paramsType)
+		method.ParamsType = copier.typeString(paramsType)
+	}
+	if result := resultOf(funcData); result != nil {
+		copier.copyNamed(result)
+		method.ResultType = copier.typeString(result)
+	}
+	for _, field := range paramFields(paramsStruct, copier.pkg) {
+		method.Encode = append(method.Encode, encodeField(field))
+	}
+	return method
+}
+
+// encodeField returns the statement putting a params field into the request.
+// Zero values are not sent, as the API treats a missing param as zero and
+// skips its min and oneof rules, except for numbers and bools with a default,
+// which a zero must override. Pointer fields are sent unless they are nil, so
+// they carry an explicit zero. Fields of nil pointer structs are skipped.
+func encodeField(field ParamField) string {
+	guards := make([]string, 0, len(field.Pointers)+1)
+	for _, pointer := range field.Pointers {
+		guards = append(guards, "in."+pointer.Path+" != nil")
+	}
+	value := "in." + field.Path
+	if field.IsPointer {
+		guards = append(guards, value+" != nil")
+		value = "*" + value
+	}
+
+	statement := encodeValue(field, value)
+	if len(guards) == 0 {
+		return statement
+	}
+	return "if " + strings.Join(guards, " && ") + " {\n" + statement + "\n}"
+}
+
+func encodeValue(field ParamField, value string) string {
+	args := parseValidatorArgs(field.Tag)
+	name := paramName(field, args)
+
+	if slice, ok := field.Type().Underlying().(*types.Slice); ok {
+		item := formatValue(fieldBasic(slice.Elem(), field.Name()), "item")
+		if args.Csv {
+			return fmt.Sprintf("if len(%s) > 0 {\n\t\titems := make([]string, 0, len(%s))\n\t\tfor _, item := range %s {\n\t\t\titems = append(items, %s)\n\t\t}\n\t\tparams.Set(%q, strings.Join(items, \",\"))\n\t}", value, value, value, item, name)
+		}
+		return fmt.Sprintf("for _, item := range %s {\n\t\tparams.Add(%q, %s)\n\t}", value, name, item)
+	}
+
+	basic := fieldBasic(field.Type(), field.Name())
+	if args.Source == "path" {
+		return fmt.Sprintf("path = strings.Replace(path, %q, url.PathEscape(%s), 1)", "{"+name+"}", formatValue(basic, value))
+	}
+	isString := basic.Info()&types.IsString != 0
+	if field.IsPointer || args.HasDefault && !isString {
+		return fmt.Sprintf("params.Set(%q, %s)", name, formatValue(basic, value))
+	}
+	sent := value + " != 0"
+	switch {
+	case isString:
+		sent = value + ` != ""`
+	case basic.Info()&types.IsBoolean != 0:
+		sent = value
+	}
+	return fmt.Sprintf("if %s {\n\t\tparams.Set(%q, %s)\n\t}", sent, name, formatValue(basic, value))
+}
+
+// formatValue returns the expression formatting a value of a basic kind the
+// way the generated handlers parse it.
+func formatValue(basic *types.Basic, value string) string {
+	info := basic.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		return "strconv.FormatBool(bool(" + value + "))"
+	case info&types.IsUnsigned != 0:
+		return "strconv.FormatUint(uint64(" + value + "), 10)"
+	case info&types.IsInteger != 0:
+		return "strconv.FormatInt(int64(" + value + "), 10)"
+	case basic.Kind() == types.Float32:
+		return "strconv.FormatFloat(float64(" + value + "), 'g', -1, 32)"
+	case info&types.IsFloat != 0:
+		return "strconv.FormatFloat(float64(" + value + "), 'g', -1, 64)"
+	}
+	return "string(" + value + ")"
+}
+
+// typeCopier copies the named types of the API package used by the client
+// into the client package, together with the named types they refer to.
+type typeCopier struct {
+	pkg     *types.Package
+	copied  map[string]string
+	imports *importSet
+}
+
+func (c *typeCopier) typeString(t types.Type) string {
+	return types.TypeString(t, c.qualifier)
+}
+
+func (c *typeCopier) qualifier(pkg *types.Package) string {
+	return c.imports.qualifier(pkg)
+}
+
+// copyNamed records the declaration of every named type of the API package
+// reachable from t.
+func (c *typeCopier) copyNamed(t types.Type) {
+	switch t := t.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() != c.pkg {
+			return
+		}
+		if _, ok := c.copied[obj.Name()]; ok {
+			return
+		}
+		// registered before the underlying type to stop on recursive types
+		c.copied[obj.Name()] = ""
+		c.copyNamed(t.Underlying())
+		c.copied[obj.Name()] = "type " + obj.Name() + " " + c.declString(t.Underlying())
+	case *types.Pointer:
+		c.copyNamed(t.Elem())
+	case *types.Slice:
+		c.copyNamed(t.Elem())
+	case *types.Array:
+		c.copyNamed(t.Elem())
+	case *types.Map:
+		c.copyNamed(t.Key())
+		c.copyNamed(t.Elem())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			c.copyNamed(t.Field(i).Type())
+		}
+	}
+}
+
+// declString is typeString keeping the struct tags in backquotes.
+func (c *typeCopier) declString(t types.Type) string {
+	structType, ok := t.(*types.Struct)
+	if !ok {
+		return c.typeString(t)
+	}
+	decl := "struct {\n"
+	for i := 0; i < structType.NumFields(); i++ {
+		field := structType.Field(i)
+		if !field.Embedded() {
+			decl += field.Name() + " "
+		}
+		decl += c.typeString(field.Type())
+		if tag := structType.Tag(i); tag != "" {
+			decl += " `" + tag + "`"
+		}
+		decl += "\n"
+	}
+	return decl + "}"
+}
+
+func (c *typeCopier) sortedNames() []string {
+	names := make([]string, 0, len(c.copied))
+	for name := range c.copied {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -2,22 +2,32 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/build"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
-)
-
-const (
-	httpServe = "func (h *%s) ServeHTTP(w http.ResponseWriter, r *http.Request) {\n"
+	"time"
 )
 
 type Enum struct {
-	Values  []string
-	Default string
+	Values []string
 }
 
 type Pair struct {
@@ -30,29 +40,108 @@ type ValidatorArgs struct {
 	ParamName string
 	Enum      Enum
 	HasEnum   bool
-	Min       int
-	HasMin    bool
-	Max       int
-	HasMax    bool
+	// Default replaces a missing value before it is parsed and checked
+	Default    string
+	HasDefault bool
+	Min        float64
+	HasMin     bool
+	Max        float64
+	HasMax     bool
+	// Source is "path" for fields bound to a {placeholder} of the url,
+	// empty for query and body parameters
+	Source string
+	// MinItems, MaxItems and Csv apply to slice fields only
+	MinItems    int
+	HasMinItems bool
+	MaxItems    int
+	HasMaxItems bool
+	Csv         bool
+	// Len, Pattern, Email, UUID and URL apply to string values, OneOf to
+	// integer ones
+	Len     int
+	HasLen  bool
+	Pattern string
+	Email   bool
+	UUID    bool
+	URL     bool
+	OneOf   []string
+}
+
+// MethodList is the "method" or "methods" of an annotation, either a single
+// HTTP method or a list of them.
+type MethodList []string
+
+func (m *MethodList) UnmarshalJSON(data []byte) error {
+	var method string
+	if err := json.Unmarshal(data, &method); err == nil {
+		*m = nil
+		if method != "" {
+			*m = MethodList{method}
+		}
+		return nil
+	}
+	var methods []string
+	if err := json.Unmarshal(data, &methods); err != nil {
+		return err
+	}
+	*m = methods
+	return nil
 }
 
 type Api struct {
-	Url    string
-	Auth   bool
-	Method string
+	Url     string
+	Auth    bool
+	Method  MethodList
+	Methods MethodList
+	Timeout string
+	// Roles and MinStatus restrict the method to callers having one of the
+	// roles or at least the status, as resolved by the API struct
+	Roles     []string
+	MinStatus *int
 }
 
 type FuncData struct {
-	Recv         *ast.Field
-	MethodName   string
-	Params       []*ast.Field
-	ReturnValues []*ast.Field
-	Api          Api
+	RecvName   string
+	MethodName string
+	Signature  *types.Signature
+	Api        Api
+	Timeout    time.Duration
+	// Methods are the HTTP methods the method serves, all of them if empty
+	Methods []string
+	// Pos is the position of the method name, ApiPos the one of its annotation
+	Pos    token.Pos
+	ApiPos token.Pos
 }
 
-type FileData struct {
+// PackageData is everything collected from the type-checked package: the
+// annotated methods and the package itself, used to resolve and qualify types.
+type PackageData struct {
 	FuncData    []FuncData
 	PackageName string
+	Package     *types.Package
+	Fset        *token.FileSet
+	// GoVersion is the go directive of the module of the package, e.g. 1.20,
+	// empty if the package is not in a module
+	GoVersion string
+}
+
+// fallbackImporter imports packages from compiled export data and falls back
+// to type-checking them from source, which is needed for packages of the
+// target module that have not been built yet.
+type fallbackImporter struct {
+	gc     types.ImporterFrom
+	source types.ImporterFrom
+}
+
+func (i fallbackImporter) Import(path string) (*types.Package, error) {
+	return i.ImportFrom(path, "", 0)
+}
+
+func (i fallbackImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	if pkg, err := i.gc.ImportFrom(path, dir, mode); err == nil {
+		return pkg, nil
+	}
+	return i.source.ImportFrom(path, dir, mode)
 }
 
 var (
@@ -65,219 +154,1552 @@ func putRes(res interface{}) []byte {
 	return jsonRes
 }
 `
-	processPostBody = `		var params string
-		if r.Method == http.MethodPost {
-			all, _ := io.ReadAll(r.Body)
-			params = string(all)
-		} else {
-			params = r.URL.RawQuery
-		}
+	putError = `
+func putError(w http.ResponseWriter, message string, code int) {
+	jsonError, _ := json.Marshal(map[string]interface{}{
+		"error": message,
+	})
+	http.Error(w, string(jsonError), code)
+}
 `
-	ifValidationError = `		if error != nil {
-		http.Error(w, "{\"error\":\"" + error.Error() + "\"}", http.StatusBadRequest)
-		return
+	// the query string and url encoded bodies are parsed with net/url: keys
+	// match exactly, values are unescaped, and for a repeated key scalar fields
+	// take the first value while slice fields take all of them. JSON bodies are
+	// flattened into the same url.Values, so every source is validated alike.
+	// requests of bodyMethods take params from the body, others from the query.
+	readParams = `
+func readParams(r *http.Request) (url.Values, error) {
+	switch r.Method {
+	case ` + goMethods(bodyMethods) + `:
+	default:
+		return url.ParseQuery(r.URL.RawQuery)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch contentType {
+	case "application/json":
+		return decodeJSONParams(r.Body)
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+		return url.Values(r.MultipartForm.Value), nil
+	default:
+		all, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return url.ParseQuery(string(all))
+	}
+}
+
+func decodeJSONParams(body io.Reader) (url.Values, error) {
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	object := make(map[string]interface{})
+	if err := decoder.Decode(&object); err != nil && err != io.EOF {
+		return nil, err
+	}
+	params := url.Values{}
+	for key, value := range object {
+		addJSONParam(params, key, value)
+	}
+	return params, nil
+}
+
+func addJSONParam(params url.Values, key string, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		params.Add(key, value)
+	case json.Number:
+		params.Add(key, value.String())
+	case bool:
+		params.Add(key, strconv.FormatBool(value))
+	case []interface{}:
+		for _, item := range value {
+			addJSONParam(params, key, item)
 		}
+	case map[string]interface{}:
+		for name, item := range value {
+			addJSONParam(params, key+"."+name, item)
+		}
+	}
+}
 `
-	ifProcessError = `		if error != nil {
-		if error.Error() == "user not exist" {
-			http.Error(w, "{\"error\":\""+error.Error()+"\"}", http.StatusNotFound)
+	// with -fielderrors convertFor* checks every param and keeps the first
+	// error of each one instead of returning on the first invalid param
+	validationErrors = `
+type fieldError struct {
+	Param   string ` + "`json:\"param\"`" + `
+	Rule    string ` + "`json:\"rule\"`" + `
+	Message string ` + "`json:\"message\"`" + `
+}
+
+type validationError struct {
+	fields []fieldError
+}
+
+func (ve *validationError) add(param string, rule string, message string) {
+	for _, field := range ve.fields {
+		if field.Param == param {
 			return
 		}
-		http.Error(w, "{\"error\":\""+error.Error()+"\"}", http.StatusInternalServerError)
-		return
+	}
+	ve.fields = append(ve.fields, fieldError{Param: param, Rule: rule, Message: message})
+}
+
+func (ve *validationError) Error() string {
+	return "validation failed"
+}
+
+func putValidationError(w http.ResponseWriter, err error) {
+	fields := make([]fieldError, 0)
+	var validation *validationError
+	if errors.As(err, &validation) {
+		fields = validation.fields
+	}
+	jsonError, _ := json.Marshal(map[string]interface{}{
+		"error":  err.Error(),
+		"fields": fields,
+	})
+	http.Error(w, string(jsonError), http.StatusBadRequest)
+}
+`
+	// path segments are matched escaped and unescaped one by one, so that
+	// an encoded slash stays inside its placeholder value
+	matchPath = `
+func matchPath(pattern string, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(pattern, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+	pathParams := make(map[string]string)
+	for i, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(pathSegments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			pathParams[segment[1:len(segment)-1]] = value
+			continue
+		}
+		if segment != pathSegments[i] {
+			return nil, false
 		}
+	}
+	return pathParams, true
+}
 `
-	ifWrongUrl = `	http.Error(w, "{\"error\":\"unknown method\"}", http.StatusNotFound)
+	hasAnyRole = `
+func hasAnyRole(roles []string, allowed ...string) bool {
+	for _, role := range roles {
+		for _, allowedRole := range allowed {
+			if role == allowedRole {
+				return true
+			}
+		}
+	}
+	return false
+}
 `
-	ifApiError = `		apiError, ok := error.(ApiError)
-			if ok {
-				http.Error(w, "{\"error\":\""+apiError.Error()+"\"}", apiError.HTTPStatus)
-				return
+	splitValues = `
+func splitValues(values []string) []string {
+	splitted := make([]string, 0, len(values))
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item != "" {
+				splitted = append(splitted, item)
 			}
+		}
+	}
+	return splitted
+}
+
+`
+	hasParamPrefix = `
+func hasParamPrefix(params url.Values, prefix string) bool {
+	for key := range params {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+`
+	methodNotAllowed = `
+func methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	putError(w, "bad method", http.StatusMethodNotAllowed)
+}
 `
 )
 
+// helpers are the functions shared by the generated handlers, each one is
+// written when the handlers refer to its name.
+var helpers = []struct {
+	name string
+	code string
+}{
+	{"readParams", readParams},
+	{"splitValues", splitValues},
+	{"hasParamPrefix", hasParamPrefix},
+	{"putError", putError},
+	{"validationError", validationErrors},
+	{"methodNotAllowed", methodNotAllowed},
+	{"putRes", putRes},
+	{"hasAnyRole", hasAnyRole},
+	{"matchPath", matchPath},
+}
+
+var (
+	errorType   = flag.String("errortype", "ApiError", "error type of the package carrying the HTTP status of the response")
+	authHeader  = flag.String("authheader", "X-Auth", "header holding the auth token when the API has no Authenticate method")
+	authStatus  = flag.Int("authstatus", http.StatusForbidden, "status of a failed authentication, 401 or 403")
+	openAPI     = flag.String("openapi", "", "also write the OpenAPI 3 document of the API to this file")
+	client      = flag.String("client", "", "also write a Go client of the API to this file")
+	clientPkg   = flag.String("clientpkg", "", "package name of the Go client, the name of its directory by default")
+	tsClient    = flag.String("ts", "", "also write a TypeScript client of the API to this file")
+	fieldErrors = flag.Bool("fielderrors", false, "validate all params and respond with the errors of every invalid one in fields")
+)
+
+var authStatuses = map[int]string{
+	http.StatusUnauthorized: "http.StatusUnauthorized",
+	http.StatusForbidden:    "http.StatusForbidden",
+}
+
 func main() {
-	data := extractData()
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: handlers_gen [flags] <package dir or file> <output file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input, output := flag.Arg(0), flag.Arg(1)
+
+	if _, ok := authStatuses[*authStatus]; !ok {
+		fmt.Fprintln(os.Stderr, "-authstatus must be 401 or 403")
+		os.Exit(2)
+	}
+
+	diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+	data := extractData(input, output, diagnostics)
 	mapData := groupByStructLink(data)
-	res, err := os.Create(os.Args[2])
+	if diagnostics.Len() == 0 {
+		checkPackage(data, mapData, diagnostics)
+	}
+	if diagnostics.Len() > 0 {
+		diagnostics.Print(os.Stderr)
+		os.Exit(1)
+	}
+
+	// every output is rendered before any is written, so that a failure
+	// leaves no half of them behind
+	outputs := make([]Output, 0, 4)
+	handlers, err := renderHandlers(data, mapData, output)
+	outputs = append(outputs, handlers)
+	if err == nil && *openAPI != "" {
+		var documents []Output
+		documents, err = renderOpenAPI(data, mapData, *openAPI)
+		outputs = append(outputs, documents...)
+	}
+	if err == nil && *client != "" {
+		var clientOutput Output
+		clientOutput, err = renderClient(data, mapData, *client, *clientPkg)
+		outputs = append(outputs, clientOutput)
+	}
+	if err == nil && *tsClient != "" {
+		var tsOutput Output
+		tsOutput, err = renderTS(data, mapData, *tsClient)
+		outputs = append(outputs, tsOutput)
+	}
+	if err == nil {
+		err = writeOutputs(outputs)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "handlers_gen:", err)
+		os.Exit(1)
+	}
+
+	fmt.Println(data.FuncData)
+}
+
+// Output is a generated file. Kind names it in the message printed once the
+// file is written, none is printed for the handlers.
+type Output struct {
+	Path    string
+	Content []byte
+	Kind    string
+}
+
+// writeOutputs writes every output next to its path first and renames them
+// once all of them are written, so that a missing directory or a full disk
+// leaves the previous files in place.
+func writeOutputs(outputs []Output) error {
+	temps := make([]string, 0, len(outputs))
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+	for _, output := range outputs {
+		temp, err := os.CreateTemp(filepath.Dir(output.Path), "."+filepath.Base(output.Path)+".*")
+		if err != nil {
+			// the error names the temporary file, the output is what matters
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return fmt.Errorf("can't write %s: %w", output.Path, err)
+		}
+		temps = append(temps, temp.Name())
+		_, err = temp.Write(output.Content)
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(temp.Name(), 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i, output := range outputs {
+		if err := os.Rename(temps[i], output.Path); err != nil {
+			return err
+		}
+		if output.Kind != "" {
+			fmt.Println(output.Kind, "written to", output.Path)
+		}
+	}
+	temps = nil
+	return nil
+}
+
+// parseFormats maps the basic kinds a param field can have to the strconv call
+// parsing it from a string, %s being replaced by the variable holding the string.
+var parseFormats = map[types.BasicKind]string{
+	types.Int:     "strconv.ParseInt(%s, 10, 0)",
+	types.Int8:    "strconv.ParseInt(%s, 10, 8)",
+	types.Int16:   "strconv.ParseInt(%s, 10, 16)",
+	types.Int32:   "strconv.ParseInt(%s, 10, 32)",
+	types.Int64:   "strconv.ParseInt(%s, 10, 64)",
+	types.Uint:    "strconv.ParseUint(%s, 10, 0)",
+	types.Uint8:   "strconv.ParseUint(%s, 10, 8)",
+	types.Uint16:  "strconv.ParseUint(%s, 10, 16)",
+	types.Uint32:  "strconv.ParseUint(%s, 10, 32)",
+	types.Uint64:  "strconv.ParseUint(%s, 10, 64)",
+	types.Float32: "strconv.ParseFloat(%s, 32)",
+	types.Float64: "strconv.ParseFloat(%s, 64)",
+	types.Bool:    "strconv.ParseBool(%s)",
+}
+
+// failure returns the statement of convertFor* rejecting the value of param
+// that breaks rule.
+type failure func(param string, rule string, message string) string
+
+// returnFailure returns on the first invalid param with message as the error.
+func returnFailure(typeName string) failure {
+	return func(param string, rule string, message string) string {
+		return fmt.Sprintf("return %s{}, errors.New(%q)", typeName, message)
+	}
+}
+
+// collectFailure records the error of param and goes on, see validationErrors.
+func collectFailure(param string, rule string, message string) string {
+	return fmt.Sprintf("validation.add(%q, %q, %q)", param, rule, message)
+}
+
+// fieldConversion returns the part of convertFor* that reads a single field
+// from params and validates it. Strings are checked as they are, every other
+// kind is parsed first and then checked against min/max by value.
+func fieldConversion(field ParamField, fail failure, qualifier types.Qualifier) FieldConversion {
+	args := parseValidatorArgs(field.Tag)
+	targetName := paramName(field, args)
+	if slice, ok := field.Type().Underlying().(*types.Slice); ok {
+		return sliceConversion(field, slice, args, targetName, fail, qualifier)
+	}
+
+	basic := fieldBasic(field.Type(), field.Name())
+	parseFormat, isParsed := parseFormats[basic.Kind()]
+	conversion := FieldConversion{
+		Var:        "field" + field.ident(),
+		StringVar:  "field" + field.ident(),
+		Source:     fmt.Sprintf("params.Get(%q)", targetName),
+		HasDefault: args.HasDefault,
+		Default:    args.Default,
+		Basic:      basic.Name(),
+	}
+	if args.Source == "path" {
+		conversion.Source = fmt.Sprintf("pathParams[%q]", targetName)
+	}
+	if isParsed {
+		conversion.StringVar = "stringField" + field.ident()
+		conversion.ParsedVar = "parsed" + field.ident()
+		conversion.Parse = fmt.Sprintf(parseFormat, conversion.StringVar)
+		conversion.TypeFail = fail(targetName, "type", targetName+" must be "+basic.Name())
+	}
+	if args.Required {
+		conversion.Presence = append(conversion.Presence, Check{
+			Cond: conversion.StringVar + ` == ""` + field.requiredIf(),
+			Fail: fail(targetName, "required", targetName+" must me not empty"),
+		})
+	}
+
+	conversion.Checks = append(rangeChecks(conversion.Var, !isParsed, args, fail, targetName), formatChecks(conversion.Var, basic, args, fail, targetName)...)
+	if args.HasEnum {
+		conversion.Checks = append(conversion.Checks, enumCheck(conversion.Var, args, fail, targetName))
+	}
+	return conversion
+}
+
+// fieldAssignment returns the assignment of a converted field to the params
+// struct. Pointer fields and pointer structs are only set when the param is
+// present in the request, even with an empty value, so that a missing param
+// can be told apart from a zero one. Pointer fields with a default and path
+// fields are always present.
+func fieldAssignment(field ParamField, qualifier types.Qualifier) FieldAssignment {
+	assignment := FieldAssignment{Path: field.Path, Value: "field" + field.ident()}
+	if !types.Identical(field.Type(), field.Type().Underlying()) {
+		assignment.Value = types.TypeString(field.Type(), qualifier) + "(" + assignment.Value + ")"
+	}
+	if !field.IsPointer && len(field.Pointers) == 0 {
+		return assignment
+	}
+
+	args := parseValidatorArgs(field.Tag)
+	if args.Source != "path" && !(args.HasDefault && len(field.Pointers) == 0) {
+		assignment.Sent = fmt.Sprintf("len(params[%q]) > 0", paramName(field, args))
+	}
+	for _, pointer := range field.Pointers {
+		assignment.Pointers = append(assignment.Pointers, PointerAllocation{Path: pointer.Path, Type: types.TypeString(pointer.Elem, qualifier)})
+	}
+	if field.IsPointer {
+		assignment.ValueVar = "value" + field.ident()
+	}
+	return assignment
+}
+
+// pathPlaceholders returns the names of the {placeholder} segments of url.
+func pathPlaceholders(url string) []string {
+	placeholders := make([]string, 0)
+	for _, segment := range strings.Split(url, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			placeholders = append(placeholders, segment[1:len(segment)-1])
+		}
+	}
+	return placeholders
+}
+
+// checkPathFields makes sure that every placeholder of the method url is
+// bound to a params field with source=path and every such field to a placeholder.
+func checkPathFields(funcData FuncData, fields []ParamField) []error {
+	placeholders := pathPlaceholders(funcData.Api.Url)
+	bound := make([]string, 0, len(placeholders))
+	errs := make([]error, 0)
+	for _, field := range fields {
+		args, err := parseValidatorTag(field.Tag)
+		if err != nil || args.Source != "path" {
+			continue
+		}
+		name := paramName(field, args)
+		if !containsString(placeholders, name) {
+			errs = append(errs, errorAt(field.Pos(), "field %s of %s is bound to {%s} missing in url %s", field.Name(), funcData.MethodName, name, funcData.Api.Url))
+		}
+		bound = append(bound, name)
+	}
+	for _, placeholder := range placeholders {
+		if !containsString(bound, placeholder) {
+			errs = append(errs, errorAt(funcData.ApiPos, "{%s} of url %s is not bound to a field with source=path", placeholder, funcData.Api.Url))
+		}
+	}
+	return errs
+}
+
+// checkDuplicateFields makes sure that no two params fields read the same
+// param or share the names of their generated variables.
+func checkDuplicateFields(funcData FuncData, fields []ParamField) []error {
+	byParam := make(map[string]ParamField, len(fields))
+	byIdent := make(map[string]ParamField, len(fields))
+	errs := make([]error, 0)
+	for _, field := range fields {
+		name := paramName(field, parseValidatorArgs(field.Tag))
+		if other, ok := byParam[name]; ok {
+			errs = append(errs, errorAt(field.Pos(), "fields %s and %s of %s both read param %s", other.Path, field.Path, funcData.MethodName, name))
+		}
+		if other, ok := byIdent[field.ident()]; ok {
+			errs = append(errs, errorAt(field.Pos(), "fields %s and %s of %s both generate variable field%s", other.Path, field.Path, funcData.MethodName, field.ident()))
+		}
+		byParam[name], byIdent[field.ident()] = field, field
+	}
+	return errs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// paramName returns the name of the parameter a field is read from:
+// paramname if set, the name from the json tag otherwise, and the lowercased
+// field name if there is neither.
+func paramName(field ParamField, args ValidatorArgs) string {
+	if args.ParamName != "" {
+		return field.Prefix + args.ParamName
+	}
+	jsonName := strings.Split(reflect.StructTag(field.Tag).Get("json"), ",")[0]
+	if jsonName != "" && jsonName != "-" {
+		return field.Prefix + jsonName
+	}
+	return field.Prefix + strings.ToLower(field.Name())
+}
+
+// sliceConversion fills a slice field from every value of the repeated
+// parameter (or from its comma separated values with the csv option). The
+// items count is checked by minitems/maxitems, every item by min/max and enum.
+func sliceConversion(field ParamField, slice *types.Slice, args ValidatorArgs, targetName string, fail failure, qualifier types.Qualifier) FieldConversion {
+	basic := fieldBasic(slice.Elem(), field.Name())
+	parseFormat, isParsed := parseFormats[basic.Kind()]
+	conversion := FieldConversion{
+		IsSlice:   true,
+		Var:       "field" + field.ident(),
+		StringVar: "stringField" + field.ident(),
+		Source:    fmt.Sprintf("params[%q]", targetName),
+		Csv:       args.Csv,
+		Basic:     basic.Name(),
+		ItemType:  types.TypeString(slice.Elem(), qualifier),
+	}
+	if args.Required {
+		conversion.Presence = append(conversion.Presence, Check{
+			Cond: "len(" + conversion.StringVar + ") == 0" + field.requiredIf(),
+			Fail: fail(targetName, "required", targetName+" must me not empty"),
+		})
+	}
+	if args.HasMinItems {
+		conversion.Presence = append(conversion.Presence, Check{
+			Cond: fmt.Sprintf("len(%s) < %d", conversion.StringVar, args.MinItems),
+			Fail: fail(targetName, "minitems", fmt.Sprintf("%s must have >= %d items", targetName, args.MinItems)),
+		})
+	}
+	if args.HasMaxItems {
+		conversion.Presence = append(conversion.Presence, Check{
+			Cond: fmt.Sprintf("len(%s) > %d", conversion.StringVar, args.MaxItems),
+			Fail: fail(targetName, "maxitems", fmt.Sprintf("%s must have <= %d items", targetName, args.MaxItems)),
+		})
+	}
+	if isParsed {
+		conversion.Parse = fmt.Sprintf(parseFormat, "stringItem")
+		conversion.TypeFail = fail(targetName, "type", targetName+" must be "+basic.Name())
+	}
+
+	conversion.Checks = append(rangeChecks("item", !isParsed, args, fail, targetName), formatChecks("item", basic, args, fail, targetName)...)
+	if args.HasEnum {
+		conversion.Checks = append(conversion.Checks, enumCheck("item", args, fail, targetName))
+	}
+	return conversion
+}
+
+// rangeChecks returns the min/max checks of a parsed value, or of the length
+// of a string value when isLen is set.
+func rangeChecks(valueName string, isLen bool, args ValidatorArgs, fail failure, targetName string) []Check {
+	checked := valueName
+	lenPrefix := ""
+	if isLen {
+		checked = "len(" + valueName + ")"
+		lenPrefix = " len"
+	}
+	checks := make([]Check, 0, 2)
+	if args.HasMax {
+		max := formatNumber(args.Max)
+		checks = append(checks, Check{checked + " > " + max, fail(targetName, "max", targetName+lenPrefix+" must be <= "+max)})
+	}
+	if args.HasMin {
+		min := formatNumber(args.Min)
+		checks = append(checks, Check{checked + " < " + min, fail(targetName, "min", targetName+lenPrefix+" must be >= "+min)})
+	}
+	return checks
+}
+
+// uuidPattern is checked by the uuid rule.
+const uuidPattern = `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`
+
+// patterns are the regular expressions checked by the generated code, each
+// one is compiled once into the package variable validatorPattern<index>.
+var patterns []string
+
+func patternVar(pattern string) string {
+	index := len(patterns)
+	for i, known := range patterns {
+		if known == pattern {
+			index = i
+		}
+	}
+	if index == len(patterns) {
+		patterns = append(patterns, pattern)
+	}
+	return "validatorPattern" + strconv.Itoa(index)
+}
+
+// formatChecks returns the len, pattern, email, uuid and url checks of a
+// string value and the oneof check of an integer one.
+func formatChecks(valueName string, basic *types.Basic, args ValidatorArgs, fail failure, targetName string) []Check {
+	checks := make([]Check, 0)
+	if args.HasLen {
+		checks = append(checks, Check{fmt.Sprintf("len(%s) != %d", valueName, args.Len), fail(targetName, "len", fmt.Sprintf("%s len must be %d", targetName, args.Len))})
+	}
+	if args.Pattern != "" {
+		checks = append(checks, Check{"!" + patternVar(args.Pattern) + ".MatchString(" + valueName + ")", fail(targetName, "pattern", targetName+" must match "+args.Pattern)})
+	}
+	if args.Email {
+		checks = append(checks, Check{fmt.Sprintf("address, err := mail.ParseAddress(%s); err != nil || address.Address != %s", valueName, valueName), fail(targetName, "email", targetName+" must be a valid email")})
+	}
+	if args.UUID {
+		checks = append(checks, Check{"!" + patternVar(uuidPattern) + ".MatchString(" + valueName + ")", fail(targetName, "uuid", targetName+" must be a valid uuid")})
+	}
+	if args.URL {
+		checks = append(checks, Check{fmt.Sprintf(`parsedURL, err := url.ParseRequestURI(%s); err != nil || parsedURL.Scheme == "" || parsedURL.Host == ""`, valueName), fail(targetName, "url", targetName+" must be a valid url")})
+	}
+	if len(args.OneOf) > 0 {
+		checks = append(checks, Check{notOneOf(valueName, basic.Name(), args.OneOf), fail(targetName, "oneof", targetName+" must be one of ["+strings.Join(args.OneOf, ", ")+"]")})
+	}
+	return checks
+}
+
+// inlineContains is set for modules older than Go 1.21, which can't import
+// slices: enum and oneof values are then compared one by one.
+var inlineContains bool
+
+// notOneOf returns the condition of a value not being one of values, the Go
+// literals of typeName.
+func notOneOf(valueName string, typeName string, values []string) string {
+	if !inlineContains {
+		return fmt.Sprintf("!slices.Contains([]%s{%s}, %s)", typeName, strings.Join(values, ", "), valueName)
+	}
+	conditions := make([]string, 0, len(values))
+	for _, value := range values {
+		conditions = append(conditions, valueName+" != "+value)
+	}
+	return strings.Join(conditions, " && ")
+}
+
+func enumCheck(valueName string, args ValidatorArgs, fail failure, targetName string) Check {
+	return Check{
+		Cond: notOneOf(valueName, "string", quoteEach(args.Enum.Values)),
+		Fail: fail(targetName, "enum", targetName+" must be one of ["+strings.Join(args.Enum.Values, ", ")+"]"),
+	}
+}
+
+// fieldBasic returns the basic type a field value is parsed into.
+func fieldBasic(fieldType types.Type, fieldName string) *types.Basic {
+	basic, ok := fieldType.Underlying().(*types.Basic)
+	if !ok {
+		panic("unsupported type " + fieldType.String() + " of field " + fieldName)
+	}
+	if _, isParsed := parseFormats[basic.Kind()]; !isParsed && basic.Kind() != types.String {
+		panic("unsupported type " + fieldType.String() + " of field " + fieldName)
+	}
+	return basic
+}
+
+// formatNumber prints a min/max argument the way it is written in Go code.
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// authenticator returns the signature of the Authenticate method of the API
+// struct recvName, or nil if it has none and the auth header check is used.
+// The method must be Authenticate(context.Context, *http.Request) (Principal, error).
+func authenticator(pkg *types.Package, recvName string) *types.Signature {
+	signature, _ := resolveHook(pkg, recvName, "Authenticate", "")
+	return signature
+}
+
+// resolveHook returns the signature of the method name of the API struct
+// recvName, or nil if it has none. Such a method is called by the generated
+// code for a request and must be name(context.Context, *http.Request) (T, error),
+// T being result unless result is empty; otherwise an error is returned.
+func resolveHook(pkg *types.Package, recvName string, name string, result string) (*types.Signature, error) {
+	recv := pkg.Scope().Lookup(recvName).Type()
+	method := types.NewMethodSet(types.NewPointer(recv)).Lookup(pkg, name)
+	if method == nil {
+		return nil, nil
+	}
+	signature := method.Type().(*types.Signature)
+	params, results := signature.Params(), signature.Results()
+	if params.Len() != 2 || params.At(0).Type().String() != "context.Context" || params.At(1).Type().String() != "*net/http.Request" ||
+		results.Len() != 2 || results.At(1).Type().String() != "error" ||
+		(result != "" && results.At(0).Type().String() != result) {
+		if result == "" {
+			result = "T"
+		}
+		return nil, errorAt(method.Obj().Pos(), "%s.%s must be %s(context.Context, *http.Request) (%s, error)", recvName, name, name, result)
+	}
+	return signature, nil
+}
+
+func quoteAll(values []string) string {
+	return strings.Join(quoteEach(values), ", ")
+}
+
+func quoteEach(values []string) []string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, strconv.Quote(value))
+	}
+	return quoted
+}
+
+// errorTargets returns the types errors.As has to look for to find typeName
+// in an error chain: the type itself when it implements error, nil otherwise,
+// and a pointer to it, as a method may return either. It is an error if there
+// is no such error type with an int HTTPStatus field in the package.
+func errorTargets(pkg *types.Package, typeName string) (types.Type, types.Type, error) {
+	object, ok := pkg.Scope().Lookup(typeName).(*types.TypeName)
+	if !ok {
+		return nil, nil, fmt.Errorf("error type %s is not declared in package %s", typeName, pkg.Name())
+	}
+	field, _, _ := types.LookupFieldOrMethod(object.Type(), true, pkg, "HTTPStatus")
+	if status, ok := field.(*types.Var); !ok || !types.Identical(status.Type().Underlying(), types.Typ[types.Int]) {
+		return nil, nil, errorAt(object.Pos(), "error type %s has no HTTPStatus int field", typeName)
+	}
+
+	errorInterface := types.Universe.Lookup("error").Type().Underlying().(*types.Interface)
+	pointer := types.NewPointer(object.Type())
+	if !types.Implements(pointer, errorInterface) {
+		return nil, nil, errorAt(object.Pos(), "error type %s does not implement error", typeName)
+	}
+	if types.Implements(object.Type(), errorInterface) {
+		return object.Type(), pointer, nil
+	}
+	return nil, pointer, nil
+}
+
+// flagSet tells if the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// paramsOf returns the params struct type of an API method, dereferenced if
+// the method takes a pointer, and its underlying struct. A method without
+// params has a nil type and an empty struct.
+func paramsOf(funcData FuncData) (types.Type, *types.Struct) {
+	if funcData.Signature.Params().Len() < 2 {
+		return nil, types.NewStruct(nil, nil)
+	}
+	paramsType := funcData.Signature.Params().At(1).Type()
+	if pointer, ok := paramsType.(*types.Pointer); ok {
+		paramsType = pointer.Elem()
+	}
+	return paramsType, paramsType.Underlying().(*types.Struct)
+}
+
+// resultOf returns the result type of an API method, nil if the method
+// returns only an error.
+func resultOf(funcData FuncData) types.Type {
+	if funcData.Signature.Results().Len() < 2 {
+		return nil
+	}
+	return funcData.Signature.Results().At(0).Type()
+}
+
+// checkSignature makes sure that the generated code can call an API method:
+// it must be Method(context.Context[, Params]) ([*Result, ]error), Params
+// being a struct or a pointer to a struct.
+func checkSignature(funcData FuncData, pkg *types.Package) error {
+	params, results := funcData.Signature.Params(), funcData.Signature.Results()
+	name := funcData.MethodName
+	typeString := func(typ types.Type) string {
+		return types.TypeString(typ, types.RelativeTo(pkg))
+	}
+	switch {
+	case params.Len() == 0 || params.Len() > 2:
+		return errorAt(funcData.Pos, "%s must take a context.Context and optionally a params struct, got %d params", name, params.Len())
+	case params.At(0).Type().String() != "context.Context":
+		return errorAt(funcData.Pos, "first param of %s must be context.Context, got %s", name, typeString(params.At(0).Type()))
+	case results.Len() == 0 || results.Len() > 2 || results.At(results.Len()-1).Type().String() != "error":
+		return errorAt(funcData.Pos, "%s must return (*Result, error) or error, got %s", name, typeString(results))
+	}
+	if results.Len() == 2 {
+		if _, isPointer := results.At(0).Type().(*types.Pointer); !isPointer {
+			return errorAt(funcData.Pos, "result of %s must be a pointer, got %s", name, typeString(results.At(0).Type()))
+		}
+	}
+	if params.Len() == 2 {
+		paramsType := params.At(1).Type()
+		if pointer, ok := paramsType.(*types.Pointer); ok {
+			paramsType = pointer.Elem()
+		}
+		if _, ok := paramsType.Underlying().(*types.Struct); !ok {
+			return errorAt(funcData.Pos, "params of %s must be a struct, got %s", name, typeString(paramsType))
+		}
+	}
+	return nil
+}
+
+// ParamField is a field of a params struct the generated code fills in.
+// Fields of nested structs are listed one by one, their Path selects them from
+// the params struct and their param name is prefixed with the names of the
+// enclosing struct fields, e.g. address.city.
+type ParamField struct {
+	*types.Var
+	Tag string
+	// Path is the selector of the field, e.g. Address.City
+	Path string
+	// Prefix is prepended to the param name, e.g. "address."
+	Prefix string
+	// Pointers are the pointer struct fields the field is reached through,
+	// they are allocated when one of their fields is sent
+	Pointers []PointerField
+	// IsPointer is set for a pointer field, it stays nil when the param is missing
+	IsPointer bool
+	// Recursive is set for a struct field of the type of an enclosing struct
+	Recursive bool
+}
+
+// PointerField is a pointer to a nested struct of the params struct.
+type PointerField struct {
+	Path string
+	Elem types.Type
+	// Prefix is the param name prefix of its fields, empty for embedded structs
+	Prefix string
+}
+
+// requiredIf is the condition the required check of a field in a pointer
+// struct is limited to: the struct is optional, but once one of its params is
+// sent its required fields are checked.
+func (field ParamField) requiredIf() string {
+	if len(field.Pointers) == 0 || field.Pointers[len(field.Pointers)-1].Prefix == "" {
+		return ""
+	}
+	return fmt.Sprintf(" && hasParamPrefix(params, %q)", field.Pointers[len(field.Pointers)-1].Prefix)
+}
+
+// Type is the type of the field value, the pointed type for pointer fields.
+func (field ParamField) Type() types.Type {
+	if pointer, ok := field.Var.Type().(*types.Pointer); ok && field.IsPointer {
+		return pointer.Elem()
+	}
+	return field.Var.Type()
+}
+
+// ident is the suffix of the generated variables of the field. The dots of the
+// path become underscores and its underscores "_0", so the suffixes of different
+// fields differ, as a field name never starts with a digit.
+func (field ParamField) ident() string {
+	return strings.NewReplacer("_", "_0", ".", "_").Replace(field.Path)
+}
+
+// paramFields returns the fields of the params struct the generated code
+// can assign: every field declared in the same package, exported ones otherwise.
+func paramFields(paramsStruct *types.Struct, pkg *types.Package) []ParamField {
+	return appendParamFields(nil, paramsStruct, pkg, ParamField{}, nil)
+}
+
+// appendParamFields appends the fields of a struct reached through parent,
+// descending into nested and embedded structs. Fields of embedded structs are
+// promoted: their param names are not prefixed, like in encoding/json.
+func appendParamFields(fields []ParamField, structType *types.Struct, pkg *types.Package, parent ParamField, visiting []types.Type) []ParamField {
+	for i := 0; i < structType.NumFields(); i++ {
+		field := structType.Field(i)
+		if !field.Exported() && field.Pkg() != pkg {
+			continue
+		}
+		paramField := ParamField{
+			Var:      field,
+			Tag:      structType.Tag(i),
+			Path:     parent.Path + field.Name(),
+			Prefix:   parent.Prefix,
+			Pointers: parent.Pointers,
+		}
+
+		fieldType := field.Type()
+		pointer, isPointer := fieldType.(*types.Pointer)
+		if isPointer {
+			fieldType = pointer.Elem()
+		}
+		nested, isStruct := fieldType.Underlying().(*types.Struct)
+		if !isStruct {
+			paramField.IsPointer = isPointer
+			fields = append(fields, paramField)
+			continue
+		}
+
+		args, tagErr := parseValidatorTag(paramField.Tag)
+		recursive := false
+		for _, visited := range visiting {
+			recursive = recursive || types.Identical(visited, fieldType)
+		}
+		// a recursive struct or one with a broken tag is listed as a field,
+		// which checkPackage reports
+		if recursive || tagErr != nil {
+			paramField.IsPointer = isPointer
+			paramField.Recursive = recursive
+			fields = append(fields, paramField)
+			continue
+		}
+		if !field.Embedded() {
+			paramField.Prefix = paramName(paramField, args) + "."
+		}
+		if isPointer {
+			pointerField := PointerField{Path: paramField.Path, Elem: fieldType}
+			if !field.Embedded() {
+				pointerField.Prefix = paramField.Prefix
+			}
+			paramField.Pointers = append(append([]PointerField{}, parent.Pointers...), pointerField)
+		}
+		leaf := paramField
+		paramField.Path += "."
+		count := len(fields)
+		fields = appendParamFields(fields, nested, pkg, paramField, append(visiting, fieldType))
+		// a struct without fields to set, like time.Time, is listed as a
+		// field, which checkPackage reports
+		if len(fields) == count {
+			leaf.IsPointer = isPointer
+			fields = append(fields, leaf)
+		}
+	}
+	return fields
+}
+
+func appendUnique(values []string, value string) []string {
+	if containsString(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// validatorKeys are the keys of the apivalidator tag, true for the ones
+// taking a value.
+var validatorKeys = map[string]bool{
+	"required":  false,
+	"csv":       false,
+	"email":     false,
+	"uuid":      false,
+	"url":       false,
+	"paramname": true,
+	"enum":      true,
+	"default":   true,
+	"min":       true,
+	"max":       true,
+	"minitems":  true,
+	"maxitems":  true,
+	"source":    true,
+	"len":       true,
+	"pattern":   true,
+	"oneof":     true,
+}
+
+// parseValidatorArgs returns the rules of the apivalidator tag of a field.
+// The tags are checked by checkPackage before any code is generated.
+func parseValidatorArgs(tag string) ValidatorArgs {
+	args, err := parseValidatorTag(tag)
 	if err != nil {
 		panic(err)
 	}
+	return args
+}
+
+// parseValidatorTag parses the apivalidator key of a struct tag: comma
+// separated options, each one either key or key=value. A comma inside a value
+// is written as \, and a | inside an enum or oneof value as \|, any other
+// backslash is kept as it is, so patterns are written as usual.
+func parseValidatorTag(tag string) (ValidatorArgs, error) {
+	args := ValidatorArgs{}
+	seen := make(map[string]bool)
+	value, ok := reflect.StructTag(tag).Lookup("apivalidator")
+	if !ok && strings.Contains(tag, `apivalidator:"`) {
+		// reflect drops a key whose value is not a valid Go string, e.g. with \d
+		return args, errors.New(`apivalidator tag is not a valid quoted string, write a backslash as \\`)
+	}
+	for _, option := range splitEscaped(value, ',') {
+		if option == "" {
+			continue
+		}
+		key, value, hasValue := strings.Cut(option, "=")
+		takesValue, known := validatorKeys[key]
+		switch {
+		case !known:
+			return args, fmt.Errorf("unknown apivalidator key %q", key)
+		case seen[key]:
+			return args, fmt.Errorf("duplicate apivalidator key %q", key)
+		case takesValue && value == "":
+			return args, fmt.Errorf("apivalidator key %q needs a value", key)
+		case !takesValue && hasValue:
+			return args, fmt.Errorf("apivalidator key %q takes no value", key)
+		}
+		seen[key] = true
 
-	fmt.Fprintln(res, "package "+data.PackageName)
-	fmt.Fprintln(res, "\nimport (\n\"net/http\"\n\"encoding/json\"\n\"strings\"\n\"strconv\"\n\"errors\"\n\"slices\"\n\"io\"\n)\n")
-	for k, v := range mapData {
-		fmt.Fprintf(res, httpServe, k)
-		fmt.Fprintln(res, "\turl := r.URL.Path")
-		for _, funcData := range v {
-			fmt.Fprintf(res, "\tif url == \"%s\"{\n", funcData.Api.Url)
-			if funcData.Api.Method != "" {
-				fmt.Fprintf(res, "\t\tif r.Method != \"%s\" {\n\t\t\thttp.Error(w, \"{\\\"error\\\":\\\"bad method\\\"}\", http.StatusNotAcceptable)\n\t\t\treturn\n\t\t}\n", funcData.Api.Method)
+		var err error
+		switch key {
+		case "required":
+			args.Required = true
+		case "csv":
+			args.Csv = true
+		case "email":
+			args.Email = true
+		case "uuid":
+			args.UUID = true
+		case "url":
+			args.URL = true
+		case "paramname":
+			args.ParamName = value
+		case "source":
+			if value != "path" {
+				err = fmt.Errorf("unknown apivalidator source %q", value)
 			}
-			if funcData.Api.Auth {
-				fmt.Fprintf(res, "\t\tauthToken := r.Header.Get(\"X-Auth\")\n\t\tif authToken == \"\" {\n\t\t\thttp.Error(w, \"{\\\"error\\\":\\\"unauthorized\\\"}\", http.StatusForbidden)\n\t\t\treturn\n\t\t}\n")
+			args.Source = value
+		case "min":
+			args.Min, err = parseNumberArg(key, value)
+			args.HasMin = true
+		case "max":
+			args.Max, err = parseNumberArg(key, value)
+			args.HasMax = true
+		case "minitems":
+			args.MinItems, err = parseCountArg(key, value)
+			args.HasMinItems = true
+		case "maxitems":
+			args.MaxItems, err = parseCountArg(key, value)
+			args.HasMaxItems = true
+		case "len":
+			args.Len, err = parseCountArg(key, value)
+			args.HasLen = true
+		case "pattern":
+			if _, compileErr := regexp.Compile(value); compileErr != nil {
+				err = fmt.Errorf("bad apivalidator pattern: %v", compileErr)
 			}
-			fmt.Fprintf(res, processPostBody)
-			fmt.Fprintf(res, "\t\tconverted, error := convertFor%s%s(params)\n", k, funcData.MethodName)
-			fmt.Fprintf(res, ifValidationError)
-			fmt.Fprintf(res, "\t\tres, error := h.%s(nil, converted)\n", funcData.MethodName)
-			fmt.Fprintf(res, ifApiError)
-			fmt.Fprintf(res, ifProcessError)
-			fmt.Fprintf(res, "\t\tw.Write(putRes(res))\n")
-			fmt.Fprintf(res, "\t\treturn\n")
-			fmt.Fprintf(res, "\t}\n")
-		}
-		fmt.Fprint(res, ifWrongUrl)
-		fmt.Fprintln(res, "}\n")
-
-		for _, funcData := range v {
-			convertableType := funcData.Params[1].Type.(*ast.Ident)
-			fmt.Fprintf(res, "func convertFor%s%s(params string) (%s, error) {\n", k, funcData.MethodName, convertableType.Name)
-			fields := convertableType.Obj.Decl.(*ast.TypeSpec).Type.(*ast.StructType).Fields.List
-			for _, field := range fields {
-				isInt := field.Type.(*ast.Ident).Name == "int"
-				args := parseValidatorArgs(field.Tag)
-				targetName := strings.ToLower(field.Names[0].Name)
-				if args.ParamName != "" {
-					targetName = args.ParamName
+			args.Pattern = value
+		case "oneof":
+			args.OneOf = splitEscaped(value, '|')
+			for _, option := range args.OneOf {
+				if _, parseErr := strconv.ParseInt(option, 10, 64); parseErr != nil {
+					err = fmt.Errorf("apivalidator oneof takes integers, got %q", option)
 				}
+			}
+		case "enum":
+			args.Enum.Values = splitEscaped(value, '|')
+			args.HasEnum = true
+		case "default":
+			args.Default = value
+			args.HasDefault = true
+		}
+		if err != nil {
+			return args, err
+		}
+	}
 
-				fieldName := "field" + field.Names[0].Name
-				stringFieldName := "stringField" + field.Names[0].Name
-				if isInt {
-					fmt.Fprintf(res, "\tvar %s int\n", fieldName)
-					fmt.Fprintf(res, "\t%s := ", stringFieldName)
-				} else {
-					fmt.Fprintf(res, "\t%s := ", fieldName)
-				}
-				fmt.Fprintf(res, "getStringValue(params, \"%s\")\n", targetName)
+	switch {
+	case args.HasMin && args.HasMax && args.Min > args.Max:
+		return args, fmt.Errorf("apivalidator min %s is greater than max %s", formatNumber(args.Min), formatNumber(args.Max))
+	case args.HasMinItems && args.HasMaxItems && args.MinItems > args.MaxItems:
+		return args, fmt.Errorf("apivalidator minitems %d is greater than maxitems %d", args.MinItems, args.MaxItems)
+	case args.HasDefault && args.Required:
+		return args, fmt.Errorf("apivalidator default is never used by a required field")
+	case args.HasDefault && args.Source == "path":
+		return args, fmt.Errorf("apivalidator default is never used by a path field")
+	case args.HasDefault && args.HasEnum && !containsString(args.Enum.Values, args.Default):
+		return args, fmt.Errorf("apivalidator default %q is not one of enum %v", args.Default, args.Enum.Values)
+	}
+	return args, nil
+}
 
-				if args.Required {
-					requiredFieldName := fieldName
-					if isInt {
-						requiredFieldName = stringFieldName
-					}
-					fmt.Fprintf(res, "\tif %s == \"\" {\n\t\treturn %s{}, errors.New(\"%s must me not empty\")\n\t}\n", requiredFieldName, convertableType.Name, targetName)
+// splitEscaped splits value at every sep not preceded by a backslash and
+// unescapes \sep in the parts.
+func splitEscaped(value string, sep byte) []string {
+	parts := make([]string, 0)
+	part := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && value[i+1] == sep:
+			part = append(part, sep)
+			i++
+		case value[i] == sep:
+			parts = append(parts, string(part))
+			part = part[:0]
+		default:
+			part = append(part, value[i])
+		}
+	}
+	return append(parts, string(part))
+}
 
-				}
+func parseNumberArg(key string, value string) (float64, error) {
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("apivalidator %s must be a number, got %q", key, value)
+	}
+	return number, nil
+}
 
-				requiredFieldName := fieldName
-				if isInt {
-					requiredFieldName = stringFieldName
-				}
-				if args.HasMin || args.HasMax {
-					fmt.Fprintf(res, "\tif %s != \"\"{\n", requiredFieldName)
-				}
-				if isInt {
-					fmt.Fprintf(res, "\t\tvar err error\n")
-					fmt.Fprintf(res, "\t\t%s, err = strconv.Atoi(%s)\n", fieldName, stringFieldName)
-					fmt.Fprintf(res, "\t\tif err != nil {\n\t\t\treturn %s{}, errors.New(\"%s must be int\")\n\t\t}\n", convertableType.Name, targetName)
-				}
+func parseCountArg(key string, value string) (int, error) {
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf("apivalidator %s must be a non-negative integer, got %q", key, value)
+	}
+	return count, nil
+}
 
-				if args.HasMax {
-					if isInt {
-						fmt.Fprintf(res, "\t\tif %s > %d{\n\t\t\treturn %s{}, errors.New(\"%s must be <= %d\")\n\t\t\t}\n", fieldName, args.Max, convertableType.Name, targetName, args.Max)
-					} else {
-						fmt.Fprintf(res, "\t\tif len(%s) > %d{\n\t\t\treturn %s{}, errors.New(\"%s len must be <= %d\")\n\t\t}\n", fieldName, args.Max, convertableType.Name, targetName, args.Max)
-					}
-				}
+// checkDefault makes sure that the default of a field is a value of its type
+// which passes the other rules of the field, as it is checked like a value
+// sent by the client.
+func checkDefault(field ParamField, args ValidatorArgs) error {
+	if _, isSlice := field.Type().Underlying().(*types.Slice); isSlice {
+		return fmt.Errorf("apivalidator default is not supported for slices")
+	}
+	basic, ok := field.Type().Underlying().(*types.Basic)
+	if !ok {
+		// the unsupported type is reported by checkField
+		return nil
+	}
 
-				if args.HasMin {
-					if isInt {
-						fmt.Fprintf(res, "\t\tif %s < %d{\n\t\t\treturn %s{}, errors.New(\"%s must be >= %d\")\n\t\t\t}\n", fieldName, args.Min, convertableType.Name, targetName, args.Min)
-					} else {
-						fmt.Fprintf(res, "\t\tif len(%s) < %d{\n\t\t\treturn %s{}, errors.New(\"%s len must be >= %d\")\n\t\t}\n", fieldName, args.Min, convertableType.Name, targetName, args.Min)
-					}
-				}
+	value, info := args.Default, basic.Info()
+	var number float64
+	switch {
+	case info&types.IsString != 0:
+		number = float64(len(value))
+	case info&types.IsBoolean != 0:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("apivalidator default %q is not a bool", value)
+		}
+		return nil
+	case info&types.IsNumeric != 0:
+		bitSize := int(types.SizesFor("gc", "amd64").Sizeof(basic) * 8)
+		var err error
+		switch {
+		case info&types.IsUnsigned != 0:
+			var unsigned uint64
+			unsigned, err = strconv.ParseUint(value, 10, bitSize)
+			number = float64(unsigned)
+		case info&types.IsInteger != 0:
+			var signed int64
+			signed, err = strconv.ParseInt(value, 10, bitSize)
+			number = float64(signed)
+		default:
+			number, err = strconv.ParseFloat(value, bitSize)
+		}
+		if err != nil {
+			return fmt.Errorf("apivalidator default %q is not %s", value, basic.Name())
+		}
+	}
 
-				if args.HasMin || args.HasMax {
-					fmt.Fprintf(res, "\t}\n")
-				}
+	switch {
+	case args.HasMin && number < args.Min:
+		return fmt.Errorf("apivalidator default %q breaks min=%s", value, formatNumber(args.Min))
+	case args.HasMax && number > args.Max:
+		return fmt.Errorf("apivalidator default %q breaks max=%s", value, formatNumber(args.Max))
+	case args.HasLen && len(value) != args.Len:
+		return fmt.Errorf("apivalidator default %q breaks len=%d", value, args.Len)
+	case args.Pattern != "" && !regexp.MustCompile(args.Pattern).MatchString(value):
+		return fmt.Errorf("apivalidator default %q breaks pattern=%s", value, args.Pattern)
+	case args.UUID && !regexp.MustCompile(uuidPattern).MatchString(value):
+		return fmt.Errorf("apivalidator default %q is not a uuid", value)
+	case args.Email && !isEmail(value):
+		return fmt.Errorf("apivalidator default %q is not an email", value)
+	case args.URL && !isURL(value):
+		return fmt.Errorf("apivalidator default %q is not a url", value)
+	}
+	for _, option := range args.OneOf {
+		if option, _ := strconv.ParseFloat(option, 64); option == number {
+			return nil
+		}
+	}
+	if len(args.OneOf) > 0 {
+		return fmt.Errorf("apivalidator default %q is not one of oneof %v", value, args.OneOf)
+	}
+	return nil
+}
 
-				if args.HasEnum {
-					if isInt {
-						panic("Can't be int")
-					}
-					fmt.Fprintf(res, "\tif %s == \"\"{\n", fieldName)
-					fmt.Fprintf(res, "\t\t%s = \"%s\"\n", fieldName, args.Enum.Default)
-					fmt.Fprintf(res, "\t} else {\n")
-					fmt.Fprintf(res, "\t\tif !slices.Contains([]string{\"%s\"}, %s){\n", strings.Join(args.Enum.Values, "\",\""), fieldName)
-					fmt.Fprintf(res, "\t\t\treturn %s{}, errors.New(\"%s must be one of [%s]\")\n", convertableType.Name, targetName, strings.Join(args.Enum.Values, ", "))
-					fmt.Fprintf(res, "\t\t}\n")
-					fmt.Fprintf(res, "\t}\n")
+// isEmail and isURL check a default the way the generated code checks a value.
+func isEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value
+}
+
+func isURL(value string) bool {
+	parsed, err := url.ParseRequestURI(value)
+	return err == nil && parsed.Scheme != "" && parsed.Host != ""
+}
+
+// checkPackage reports every problem of the annotated methods that would
+// stop the code from being generated: hooks with a wrong signature, routes
+// served twice, methods the generated code can't call, invalid apivalidator
+// tags, fields of unsupported types and path placeholders without a field.
+func checkPackage(data PackageData, mapData map[string][]FuncData, diagnostics *Diagnostics) {
+	// the default error type may be missing, an explicit one must not
+	if flagSet("errortype") {
+		if _, _, err := errorTargets(data.Package, *errorType); err != nil {
+			diagnostics.Add(err)
+		}
+	}
+	checked := make(map[token.Pos]bool)
+	for _, recvName := range sortedKeys(mapData) {
+		if _, err := resolveHook(data.Package, recvName, "Authenticate", ""); err != nil {
+			diagnostics.Add(err)
+		}
+		for _, route := range groupByUrl(mapData[recvName]) {
+			if err := checkRoute(route); err != nil {
+				diagnostics.Add(err)
+			}
+		}
+
+		for _, funcData := range mapData[recvName] {
+			if len(funcData.Api.Roles) > 0 {
+				if resolveRoles, err := resolveHook(data.Package, recvName, "ResolveRoles", "[]string"); err != nil {
+					diagnostics.Add(err)
+				} else if resolveRoles == nil {
+					diagnostics.Errorf(funcData.ApiPos, "%s must implement ResolveRoles(context.Context, *http.Request) ([]string, error) for roles of %s", recvName, funcData.MethodName)
+				}
+			}
+			if funcData.Api.MinStatus != nil {
+				if resolveStatus, err := resolveHook(data.Package, recvName, "ResolveStatus", "int"); err != nil {
+					diagnostics.Add(err)
+				} else if resolveStatus == nil {
+					diagnostics.Errorf(funcData.ApiPos, "%s must implement ResolveStatus(context.Context, *http.Request) (int, error) for minStatus of %s", recvName, funcData.MethodName)
 				}
+			}
 
+			if err := checkSignature(funcData, data.Package); err != nil {
+				diagnostics.Add(err)
+				continue
 			}
-			fmt.Fprintf(res, "\treturn %s{\n", convertableType.Name)
+			_, paramsStruct := paramsOf(funcData)
 
+			fields := paramFields(paramsStruct, data.Package)
+			valid := true
 			for _, field := range fields {
-				value := "field" + field.Names[0].Name
-				fmt.Fprintf(res, "\t\t%s:%s,\n", field.Names[0].Name, value)
+				if err := checkField(field); err != nil {
+					valid = false
+					if !checked[field.Pos()] {
+						diagnostics.Errorf(field.Pos(), "field %s: %v", field.Name(), err)
+					}
+				}
+				checked[field.Pos()] = true
 			}
-			fmt.Fprintln(res, "\t}, nil\n")
+			if valid {
+				for _, err := range checkPathFields(funcData, fields) {
+					diagnostics.Add(err)
+				}
+				for _, err := range checkDuplicateFields(funcData, fields) {
+					diagnostics.Add(err)
+				}
+			}
+		}
+	}
+}
+
+// checkField checks the apivalidator tag of a params field and makes sure
+// that the generated code can read the field and its rules apply to its type.
+func checkField(field ParamField) error {
+	args, err := parseValidatorTag(field.Tag)
+	if err != nil {
+		return err
+	}
 
-			fmt.Fprintln(res, "}\n")
+	fieldType := field.Type()
+	if _, isStruct := fieldType.Underlying().(*types.Struct); isStruct {
+		if field.Recursive {
+			return fmt.Errorf("params field %s is recursive", field.Path)
+		}
+		return fmt.Errorf("unsupported type %s, it has no fields to set", field.Type())
+	}
+	if slice, isSlice := fieldType.Underlying().(*types.Slice); isSlice {
+		if args.Source == "path" {
+			return errors.New("slice field can't be read from path")
 		}
+		fieldType = slice.Elem()
+	}
+	basic, ok := fieldType.Underlying().(*types.Basic)
+	if !ok {
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	if _, isParsed := parseFormats[basic.Kind()]; !isParsed && basic.Kind() != types.String {
+		return fmt.Errorf("unsupported type %s", field.Type())
 	}
-	fmt.Fprintln(res, "func getStringValue(value string, name string) string {\n\tparamnameIndex := strings.Index(value, name)\n\tif paramnameIndex != -1 {\n\t\tcuttedStart := value[paramnameIndex+len(name) + 1:]\n\t\tfirstAmpersand := strings.Index(cuttedStart, \"&\")\n\t\tif firstAmpersand == -1 {\n\t\t\treturn cuttedStart\n\t\t}\n\t\treturn cuttedStart[:firstAmpersand]\n\t} else {\n\t\treturn \"\"\n\t}\n}")
-	fmt.Fprintf(res, putRes)
 
-	fmt.Println(data.FuncData)
+	isString := basic.Info()&types.IsString != 0
+	switch {
+	case args.HasEnum && !isString:
+		return fmt.Errorf("enum applies to strings only, use oneof for %s", basic.Name())
+	case (args.HasLen || args.Pattern != "" || args.Email || args.UUID || args.URL) && !isString:
+		return fmt.Errorf("len, pattern, email, uuid and url apply to strings only, not %s", basic.Name())
+	case len(args.OneOf) > 0 && basic.Info()&types.IsInteger == 0:
+		return fmt.Errorf("oneof applies to integers only, not %s, use enum for strings", basic.Name())
+	}
+	if args.HasMin {
+		if err := checkBound(basic, "min", args.Min); err != nil {
+			return err
+		}
+	}
+	if args.HasMax {
+		if err := checkBound(basic, "max", args.Max); err != nil {
+			return err
+		}
+	}
+	if args.HasDefault {
+		return checkDefault(field, args)
+	}
+	return nil
 }
 
-// TODO обработать ситуацию, когда нет никаких tag
-func parseValidatorArgs(stringArgs *ast.BasicLit) ValidatorArgs {
-	value := stringArgs.Value
-	args := ValidatorArgs{}
-	if strings.Contains(value, "required") {
-		args.Required = true
+// checkBound makes sure that a min or max bound compiles when compared with a
+// value of basic, or with its length for strings: integers and lengths take
+// whole numbers in the range of their type.
+func checkBound(basic *types.Basic, key string, bound float64) error {
+	info := basic.Info()
+	if info&types.IsBoolean != 0 {
+		return fmt.Errorf("apivalidator %s does not apply to bool", key)
+	}
+	if info&types.IsFloat != 0 {
+		if basic.Kind() == types.Float32 && math.Abs(bound) > math.MaxFloat32 {
+			return fmt.Errorf("apivalidator %s=%s overflows float32", key, formatNumber(bound))
+		}
+		return nil
+	}
+	if bound != math.Trunc(bound) {
+		return fmt.Errorf("apivalidator %s=%s is not a whole number", key, formatNumber(bound))
+	}
+	if info&types.IsString != 0 {
+		if bound < 0 {
+			return fmt.Errorf("apivalidator %s=%s is a negative length", key, formatNumber(bound))
+		}
+		return nil
 	}
 
-	args.ParamName = getValueFromString(value, "paramname")
-	minString := getValueFromString(value, "min")
-	if minString != "" {
-		args.Min, _ = strconv.Atoi(minString)
-		args.HasMin = true
+	bitSize := int(types.SizesFor("gc", "amd64").Sizeof(basic) * 8)
+	low, high := -math.Ldexp(1, bitSize-1), math.Ldexp(1, bitSize-1)
+	if info&types.IsUnsigned != 0 {
+		low, high = 0, math.Ldexp(1, bitSize)
 	}
-	maxString := getValueFromString(value, "max")
-	if maxString != "" {
-		args.Max, _ = strconv.Atoi(maxString)
-		args.HasMax = true
+	// high is a power of two, exact as a float64, and out of range itself
+	if bound < low || bound >= high {
+		return fmt.Errorf("apivalidator %s=%s overflows %s", key, formatNumber(bound), basic.Name())
 	}
+	return nil
+}
 
-	enumString := getValueFromString(value, "enum")
-	defaultString := getValueFromString(value, "default")
-	if enumString != "" {
-		args.Enum = Enum{
-			Values:  strings.Split(enumString, "|"),
-			Default: defaultString,
+// Route is a url served by one or several API methods.
+type Route struct {
+	Url       string
+	FuncData  []FuncData
+	IsPattern bool
+}
+
+// groupByUrl groups the methods of an API struct by url, keeping the order
+// they are declared in.
+func groupByUrl(funcData []FuncData) []Route {
+	routes := make([]Route, 0, len(funcData))
+	indexes := make(map[string]int)
+	for _, datum := range funcData {
+		index, ok := indexes[datum.Api.Url]
+		if !ok {
+			index = len(routes)
+			indexes[datum.Api.Url] = index
+			routes = append(routes, Route{
+				Url:       datum.Api.Url,
+				IsPattern: len(pathPlaceholders(datum.Api.Url)) > 0,
+			})
 		}
-		args.HasEnum = true
+		routes[index].FuncData = append(routes[index].FuncData, datum)
+	}
+	return routes
+}
+
+// routeSwitch dispatches a request to the method of the route serving its
+// HTTP method. A method without "method" in its annotation serves every
+// HTTP method no other method of the route is declared for. Otherwise HEAD is
+// served by the GET method, OPTIONS is answered with the Allow header and any
+// other HTTP method with 405 and the Allow header.
+func routeSwitch(route Route, pathArg string) HandlersRoute {
+	byMethod, catchAll, _ := routeMethods(route)
+	switchData := HandlersRoute{Url: route.Url, PathArg: pathArg}
+	if catchAll != nil {
+		switchData.CatchAll = catchAll.MethodName
+	}
+	if len(byMethod) == 0 {
+		return switchData
 	}
 
-	return args
+	if get, ok := byMethod[http.MethodGet]; ok {
+		if _, ok := byMethod[http.MethodHead]; !ok {
+			byMethod[http.MethodHead] = get
+		}
+	}
+	_, hasOptions := byMethod[http.MethodOptions]
+	switchData.AnswerOptions = !hasOptions && catchAll == nil
+	allowed := make([]string, 0, len(byMethod)+1)
+	for method := range byMethod {
+		allowed = append(allowed, method)
+	}
+	if switchData.AnswerOptions {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	sort.Strings(allowed)
+	switchData.Allow = strings.Join(allowed, ", ")
+
+	for _, method := range allowed {
+		if funcData, ok := byMethod[method]; ok {
+			switchData.Cases = append(switchData.Cases, MethodCase{HTTPMethod: method, Method: funcData.MethodName})
+		}
+	}
+	return switchData
 }
 
-func getValueFromString(value string, name string) string {
-	paramnameIndex := strings.Index(value, name)
-	if paramnameIndex != -1 {
-		cuttedStart := value[paramnameIndex+len(name)+1:]
-		firstDoubleQuote := strings.Index(cuttedStart, "\"")
-		firstComma := strings.Index(cuttedStart, ",")
-		if firstComma == -1 || firstComma > firstDoubleQuote {
-			return cuttedStart[:firstDoubleQuote]
+// routeMethods maps the HTTP methods of a route to the methods serving them
+// and returns the method serving any other HTTP method, nil if there is none.
+// Two methods serving the same HTTP method are an error.
+func routeMethods(route Route) (map[string]FuncData, *FuncData, error) {
+	byMethod := make(map[string]FuncData)
+	var catchAll *FuncData
+	for i, funcData := range route.FuncData {
+		if len(funcData.Methods) == 0 {
+			if catchAll != nil {
+				return nil, nil, errorAt(funcData.ApiPos, "methods %s and %s both serve any method of %s", catchAll.MethodName, funcData.MethodName, route.Url)
+			}
+			catchAll = &route.FuncData[i]
+			continue
+		}
+		for _, method := range funcData.Methods {
+			if other, ok := byMethod[method]; ok {
+				return nil, nil, errorAt(funcData.ApiPos, "methods %s and %s both serve %s %s", other.MethodName, funcData.MethodName, method, route.Url)
+			}
+			byMethod[method] = funcData
+		}
+	}
+	return byMethod, catchAll, nil
+}
+
+// checkRoute reports the conflicts of routeMethods and a catch-all method the
+// clients can't call, as the other methods of the url serve anyMethods.
+func checkRoute(route Route) error {
+	_, catchAll, err := routeMethods(route)
+	if err != nil || catchAll == nil {
+		return err
+	}
+	if len(operationMethods(route.FuncData)[catchAll.MethodName]) == 0 {
+		return errorAt(catchAll.ApiPos, "method %s serves any method of %s, but the other methods serve %s", catchAll.MethodName, route.Url, strings.Join(anyMethods(), ", "))
+	}
+	return nil
+}
+
+// catchAllMethods are the HTTP methods a method without "method" in its
+// annotation is documented and called with, unless another method of its url
+// serves them. otherMethods are used when the others serve both.
+var (
+	catchAllMethods = []string{http.MethodGet, http.MethodPost}
+	otherMethods    = []string{http.MethodPut, http.MethodPatch, http.MethodDelete}
+)
+
+// anyMethods are the HTTP methods a method without "method" in its annotation
+// can be documented with, in order.
+func anyMethods() []string {
+	return append(append([]string{}, catchAllMethods...), otherMethods...)
+}
+
+// bodyMethods are the HTTP methods whose params readParams takes from the
+// body, the params of other methods come from the query.
+var bodyMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch}
+
+func paramsInBody(httpMethod string) bool {
+	return containsString(bodyMethods, httpMethod)
+}
+
+// routeOperations maps the HTTP methods the OpenAPI document and the clients
+// use for a route to the methods the router dispatches them to.
+func routeOperations(route Route) map[string]FuncData {
+	byMethod, catchAll, _ := routeMethods(route)
+	if catchAll == nil {
+		return byMethod
+	}
+	claimed := len(byMethod)
+	for _, method := range catchAllMethods {
+		if _, ok := byMethod[method]; !ok {
+			byMethod[method] = *catchAll
+		}
+	}
+	if len(byMethod) > claimed {
+		return byMethod
+	}
+	for _, method := range otherMethods {
+		if _, ok := byMethod[method]; !ok {
+			byMethod[method] = *catchAll
+			break
+		}
+	}
+	return byMethod
+}
+
+// operationMethods maps the methods of an API struct to the HTTP methods they
+// are documented with, in the order of their annotation. The clients call the
+// first one.
+func operationMethods(funcData []FuncData) map[string][]string {
+	methods := make(map[string][]string, len(funcData))
+	for _, route := range groupByUrl(funcData) {
+		operations := routeOperations(route)
+		for _, datum := range route.FuncData {
+			candidates := datum.Methods
+			if len(candidates) == 0 {
+				candidates = anyMethods()
+			}
+			for _, method := range candidates {
+				if operations[method].MethodName == datum.MethodName {
+					methods[datum.MethodName] = append(methods[datum.MethodName], method)
+				}
+			}
 		}
-		return cuttedStart[:firstComma]
-	} else {
-		return ""
 	}
+	return methods
 }
 
-func groupByStructLink(data FileData) map[string][]FuncData {
+// methodConstants are the net/http constants of the standard HTTP methods.
+var methodConstants = map[string]string{
+	http.MethodGet:     "http.MethodGet",
+	http.MethodHead:    "http.MethodHead",
+	http.MethodPost:    "http.MethodPost",
+	http.MethodPut:     "http.MethodPut",
+	http.MethodPatch:   "http.MethodPatch",
+	http.MethodDelete:  "http.MethodDelete",
+	http.MethodConnect: "http.MethodConnect",
+	http.MethodOptions: "http.MethodOptions",
+	http.MethodTrace:   "http.MethodTrace",
+}
+
+// goMethods returns the net/http constants of HTTP methods, e.g. http.MethodPost,
+// and a string literal for a method net/http has no constant for.
+func goMethods(httpMethods []string) string {
+	constants := make([]string, 0, len(httpMethods))
+	for _, method := range httpMethods {
+		constant, ok := methodConstants[method]
+		if !ok {
+			constant = strconv.Quote(method)
+		}
+		constants = append(constants, constant)
+	}
+	return strings.Join(constants, ", ")
+}
+
+func sortedKeys(mapData map[string][]FuncData) []string {
+	keys := make([]string, 0, len(mapData))
+	for key := range mapData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func groupByStructLink(data PackageData) map[string][]FuncData {
 	mapData := make(map[string][]FuncData)
 	for _, datum := range data.FuncData {
-		name := datum.Recv.Type.(*ast.StarExpr).X.(*ast.Ident).Name
+		name := datum.RecvName
 		funcData, ext := mapData[name]
 		if !ext {
 			funcData = []FuncData{datum}
@@ -289,13 +1711,118 @@ func groupByStructLink(data FileData) map[string][]FuncData {
 	return mapData
 }
 
-func extractData() FileData {
-	set := token.NewFileSet()
-	f, err := parser.ParseFile(set, os.Args[1], nil, parser.ParseComments)
+// extractData parses every file of the package located at path (a package
+// directory or any file inside it). Files excluded by build tags, test files,
+// previously generated files and the output file itself are skipped. Syntax
+// errors and broken annotations are reported to diagnostics.
+func extractData(path string, output string, diagnostics *Diagnostics) PackageData {
+	data := PackageData{
+		FuncData: make([]FuncData, 0),
+		Fset:     diagnostics.Fset,
+	}
+	dir := path
+	if info, err := os.Stat(path); err != nil {
+		diagnostics.Add(err)
+		return data
+	} else if !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	pkg, err := build.ImportDir(dir, 0)
 	if err != nil {
-		panic(err)
+		diagnostics.Add(err)
+		return data
 	}
-	funcData := make([]FuncData, 0)
+	outputAbs, _ := filepath.Abs(output)
+
+	set := diagnostics.Fset
+	files := make([]*ast.File, 0, len(pkg.GoFiles))
+	for _, name := range append(pkg.GoFiles, pkg.CgoFiles...) {
+		fileName := filepath.Join(dir, name)
+		if fileAbs, _ := filepath.Abs(fileName); fileAbs == outputAbs {
+			fmt.Println("It is output file. Skip", fileName)
+			continue
+		}
+
+		f, err := parser.ParseFile(set, fileName, nil, parser.ParseComments)
+		if err != nil {
+			diagnostics.Add(err)
+			continue
+		}
+		if isGenerated(f) {
+			fmt.Println("It is generated file. Skip", fileName)
+			continue
+		}
+		files = append(files, f)
+	}
+	if diagnostics.Len() > 0 {
+		return data
+	}
+
+	// the package usually does not compile before its handlers are
+	// generated (ServeHTTP is missing), so type errors are ignored; setting
+	// Error keeps the checker going past the first one
+	info := &types.Info{Defs: make(map[*ast.Ident]types.Object)}
+	conf := types.Config{
+		Importer: fallbackImporter{
+			gc:     importer.ForCompiler(set, "gc", nil).(types.ImporterFrom),
+			source: importer.ForCompiler(set, "source", nil).(types.ImporterFrom),
+		},
+		Error: func(error) {},
+	}
+	data.Package, _ = conf.Check(pkg.ImportPath, set, files, info)
+	data.PackageName = pkg.Name
+	data.GoVersion = moduleGoVersion(dir)
+	for _, f := range files {
+		extractFileData(f, info, &data, diagnostics)
+	}
+	return data
+}
+
+// moduleGoVersion returns the go directive of the go.mod of the module the
+// directory dir belongs to, empty if there is none.
+func moduleGoVersion(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(content), "\n") {
+				if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "go" {
+					return fields[1]
+				}
+			}
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// goVersionAtLeast reports whether the go directive version is 1.minor or
+// later. An empty version is the one of the running toolchain.
+func goVersionAtLeast(version string, minor int) bool {
+	if version == "" {
+		return true
+	}
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	end := 0
+	for end < len(parts[1]) && parts[1][end] >= '0' && parts[1][end] <= '9' {
+		end++
+	}
+	versionMinor, err := strconv.Atoi(parts[1][:end])
+	return err == nil && (parts[0] != "1" || versionMinor >= minor)
+}
+
+func extractFileData(f *ast.File, info *types.Info, data *PackageData, diagnostics *Diagnostics) {
 	for _, decl := range f.Decls {
 		funcDecl, ok := decl.(*ast.FuncDecl)
 		if !ok {
@@ -303,46 +1830,113 @@ func extractData() FileData {
 			continue
 		}
 
-		apigenString, containsApigen := getApigenString(funcDecl.Doc)
-		if !containsApigen {
+		comment := getApigenComment(funcDecl.Doc)
+		if comment == nil {
 			fmt.Println("It is not apigen. Skip")
 			continue
 		}
 
 		apigen := new(Api)
-		substr := apigenString[strings.IndexRune(apigenString, '{'):]
+		start := strings.IndexRune(comment.Text, '{')
+		if start < 0 {
+			diagnostics.Errorf(comment.Pos(), "apigen annotation must be followed by a JSON object")
+			continue
+		}
+		substr := comment.Text[start:]
 		fmt.Println("Found apigen: ", substr)
-		bytes := []byte(substr)
-		err := json.Unmarshal(bytes, apigen)
-		if err != nil {
-			panic(err)
+		if err := json.Unmarshal([]byte(substr), apigen); err != nil {
+			diagnostics.Errorf(comment.Pos(), "bad apigen JSON: %v", err)
+			continue
+		}
+		if apigen.Url == "" {
+			diagnostics.Errorf(comment.Pos(), "apigen url is empty")
+		}
+
+		methods := make([]string, 0, len(apigen.Method)+len(apigen.Methods))
+		for _, method := range append(apigen.Method, apigen.Methods...) {
+			methods = appendUnique(methods, strings.ToUpper(method))
+		}
+
+		var timeout time.Duration
+		if apigen.Timeout != "" {
+			var err error
+			timeout, err = time.ParseDuration(apigen.Timeout)
+			if err != nil {
+				diagnostics.Errorf(comment.Pos(), "bad apigen timeout: %v", err)
+			} else if timeout <= 0 {
+				diagnostics.Errorf(comment.Pos(), "apigen timeout must be positive, got %s", apigen.Timeout)
+			}
+		}
+
+		function, ok := info.Defs[funcDecl.Name].(*types.Func)
+		if !ok {
+			diagnostics.Errorf(funcDecl.Name.Pos(), "%s is not type-checked", funcDecl.Name.Name)
+			continue
+		}
+		signature := function.Type().(*types.Signature)
+		if signature.Recv() == nil {
+			diagnostics.Errorf(funcDecl.Name.Pos(), "%s is annotated with apigen but is not a method", funcDecl.Name.Name)
+			continue
+		}
+		name, ok := recvName(signature.Recv().Type())
+		if !ok {
+			diagnostics.Errorf(funcDecl.Recv.Pos(), "receiver of %s must be a named struct type", funcDecl.Name.Name)
+			continue
 		}
 
-		funcData = append(funcData, FuncData{
+		data.FuncData = append(data.FuncData, FuncData{
 			Api:        *apigen,
-			Recv:       funcDecl.Recv.List[0],
+			Timeout:    timeout,
+			Methods:    methods,
+			RecvName:   name,
 			MethodName: funcDecl.Name.Name,
-			Params:     funcDecl.Type.Params.List,
+			Signature:  signature,
+			Pos:        funcDecl.Name.Pos(),
+			ApiPos:     comment.Pos(),
 		})
 	}
+}
 
-	data := FileData{
-		FuncData:    funcData,
-		PackageName: f.Name.Name,
+// recvName returns the name of the receiver's base type, T for both T and *T.
+func recvName(recv types.Type) (string, bool) {
+	if pointer, ok := recv.(*types.Pointer); ok {
+		recv = pointer.Elem()
 	}
-	return data
+	named, ok := recv.(*types.Named)
+	if !ok {
+		return "", false
+	}
+	return named.Obj().Name(), true
 }
 
-func getApigenString(doc *ast.CommentGroup) (string, bool) {
+// isGenerated reports whether f carries the standard
+// "Code generated ... DO NOT EDIT." marker before its package clause.
+func isGenerated(f *ast.File) bool {
+	for _, group := range f.Comments {
+		if group.Pos() >= f.Package {
+			break
+		}
+		for _, comment := range group.List {
+			text := comment.Text
+			if strings.HasPrefix(text, "// Code generated ") && strings.HasSuffix(text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getApigenComment returns the comment of doc holding the apigen annotation,
+// nil if there is none.
+func getApigenComment(doc *ast.CommentGroup) *ast.Comment {
 	if doc == nil {
-		return "", false
+		return nil
 	}
 
-	comments := doc.List
-	for _, comment := range comments {
+	for _, comment := range doc.List {
 		if strings.Contains(comment.Text, "apigen") {
-			return comment.Text, true
+			return comment
 		}
 	}
-	return "", false
+	return nil
 }
@@ -0,0 +1,1047 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden file with the generated handlers")
+
+const goldenFile = "testdata/api_handlers.go.golden"
+
+// TestGolden generates the handlers of api.go and compares them with the
+// golden file. Run go test ./handlers_gen -update after an intended change of
+// the generated code.
+func TestGolden(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "api_handlers.go")
+	diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+	data := extractData("../api.go", output, diagnostics)
+	mapData := groupByStructLink(data)
+	checkPackage(data, mapData, diagnostics)
+	if diagnostics.Len() > 0 {
+		printed := new(bytes.Buffer)
+		diagnostics.Print(printed)
+		t.Fatalf("api.go has problems:\n%s", printed)
+	}
+	writeRendered(t)(renderHandlers(data, mapData, output))
+
+	compareGolden(t, output, goldenFile)
+}
+
+// compareGolden compares a generated file with its golden file, or rewrites
+// the golden file with -update.
+func compareGolden(t *testing.T, output string, golden string) {
+	t.Helper()
+	generated, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := os.WriteFile(golden, generated, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(generated, want) {
+		t.Errorf("generated %s differs from %s, run go test ./handlers_gen -update if the change is intended", filepath.Base(output), golden)
+	}
+}
+
+const tsGoldenFile = "testdata/features.ts.golden"
+
+// TestTSGolden generates the TypeScript client of testdata/features and
+// compares it with the golden file. With tsc in PATH the client is
+// type-checked as well.
+func TestTSGolden(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "api.ts")
+	diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+	data := extractData("testdata/features", output, diagnostics)
+	mapData := groupByStructLink(data)
+	checkPackage(data, mapData, diagnostics)
+	if diagnostics.Len() > 0 {
+		printed := new(bytes.Buffer)
+		diagnostics.Print(printed)
+		t.Fatalf("testdata/features has problems:\n%s", printed)
+	}
+	writeRendered(t)(renderTS(data, mapData, output))
+	compareGolden(t, output, tsGoldenFile)
+
+	tsc, err := exec.LookPath("tsc")
+	if err != nil || testing.Short() {
+		return
+	}
+	cmd := exec.Command(tsc, "--noEmit", "--strict", "--target", "es2018", "--lib", "es2018,dom", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("tsc rejects the TypeScript client: %v\n%s", err, out)
+	}
+}
+
+// TestGoldenGoVersion type-checks the golden file with the package it is
+// generated for under the go version of go.mod, and makes sure that it uses no
+// standard library API added after that version.
+func TestGoldenGoVersion(t *testing.T) {
+	version := moduleGoVersion("..")
+	if version == "" {
+		t.Fatal("go.mod has no go directive")
+	}
+
+	fset := token.NewFileSet()
+	files := make([]*ast.File, 0, 3)
+	for _, name := range []string{"../api.go", "../main.go", goldenFile} {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{
+		GoVersion: "go" + version,
+		Importer:  importer.ForCompiler(fset, "source", nil),
+	}
+	info := &types.Info{Uses: make(map[*ast.Ident]types.Object)}
+	if _, err := conf.Check("codegenhw", fset, files, info); err != nil {
+		t.Fatalf("golden file does not build under go %s: %v", version, err)
+	}
+
+	added := addedAPI(t, version)
+	for ident, object := range info.Uses {
+		position := fset.Position(ident.Pos())
+		if position.Filename != goldenFile || object.Pkg() == nil || object.Parent() != object.Pkg().Scope() {
+			continue
+		}
+		if since, ok := added[object.Pkg().Path()+"."+object.Name()]; ok {
+			t.Errorf("%s: %s.%s is added in %s, go.mod declares go %s", position, object.Pkg().Path(), object.Name(), since, version)
+		}
+	}
+}
+
+// addedAPI returns the package level names of the standard library added after
+// version, e.g. slices.Contains, mapped to the release adding them. The names
+// are read from the api directory of GOROOT.
+func addedAPI(t *testing.T, version string) map[string]string {
+	releases, _ := filepath.Glob(filepath.Join(build.Default.GOROOT, "api", "go1.*.txt"))
+	if len(releases) == 0 {
+		t.Skip("no api files in GOROOT")
+	}
+	added := make(map[string]string)
+	for _, release := range releases {
+		name := strings.TrimSuffix(filepath.Base(release), ".txt")
+		minor, err := strconv.Atoi(strings.TrimPrefix(name, "go1."))
+		if err != nil || goVersionAtLeast(version, minor) {
+			continue
+		}
+		content, err := os.ReadFile(release)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			// e.g. pkg slices, func Contains[$0 interface{ ~[]$1 }, $1 comparable]($0, $1) bool
+			pkg, decl, ok := strings.Cut(strings.TrimPrefix(line, "pkg "), ", ")
+			if !ok || !strings.HasPrefix(line, "pkg ") {
+				continue
+			}
+			pkg, _, _ = strings.Cut(pkg, " (")
+			kind, rest, _ := strings.Cut(decl, " ")
+			// struct fields and interface methods of an existing type are
+			// listed after the type, e.g. type T struct, NewField int
+			if kind == "type" && strings.Contains(rest, ", ") || kind != "func" && kind != "type" && kind != "var" && kind != "const" {
+				continue
+			}
+			if end := strings.IndexAny(rest, " [("); end >= 0 {
+				rest = rest[:end]
+			}
+			added[pkg+"."+rest] = name
+		}
+	}
+	return added
+}
+
+// TestCheckSignature makes sure that the methods the generated code can call
+// are accepted and the others are reported.
+func TestCheckSignature(t *testing.T) {
+	const source = `package api
+
+import "context"
+
+type Api struct{}
+type Params struct{ Name string }
+type Result struct{}
+
+func (a *Api) Full(ctx context.Context, in Params) (*Result, error) { return nil, nil }
+func (a *Api) PointerParams(ctx context.Context, in *Params) (*Result, error) { return nil, nil }
+func (a *Api) NoParams(ctx context.Context) (*Result, error) { return nil, nil }
+func (a *Api) OnlyError(ctx context.Context, in Params) error { return nil }
+func (a *Api) NoContext(in Params) (*Result, error) { return nil, nil }
+func (a *Api) NoArgs() error { return nil }
+func (a *Api) TooManyParams(ctx context.Context, in Params, more Params) error { return nil }
+func (a *Api) ValueResult(ctx context.Context, in Params) (Result, error) { return Result{}, nil }
+func (a *Api) NoError(ctx context.Context, in Params) *Result { return nil }
+func (a *Api) NotStruct(ctx context.Context, in string) error { return nil }
+`
+	pkg := typeCheck(t, source)
+	for name, want := range map[string]string{
+		"Full":          "",
+		"PointerParams": "",
+		"NoParams":      "",
+		"OnlyError":     "",
+		"NoContext":     "first param of NoContext must be context.Context, got Params",
+		"NoArgs":        "NoArgs must take a context.Context and optionally a params struct, got 0 params",
+		"TooManyParams": "TooManyParams must take a context.Context and optionally a params struct, got 3 params",
+		"ValueResult":   "result of ValueResult must be a pointer, got Result",
+		"NoError":       "NoError must return (*Result, error) or error, got (*Result)",
+		"NotStruct":     "params of NotStruct must be a struct, got string",
+	} {
+		funcData := apiMethod(pkg, name)
+		got := ""
+		if err := checkSignature(funcData, pkg); err != nil {
+			got = err.Error()
+		}
+		if got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestCheckFieldUnsupported makes sure that params fields of types the
+// generated code can't read are reported instead of crashing the generator.
+func TestCheckFieldUnsupported(t *testing.T) {
+	const source = `package api
+
+import "time"
+
+type Params struct {
+	Meta  map[string]string
+	Any   interface{}
+	Chan  chan int
+	Func  func()
+	Items []map[string]int
+	At    time.Time
+	Empty struct{}
+}
+`
+	pkg := typeCheck(t, source)
+	paramsStruct := pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct)
+	want := map[string]string{
+		"Meta":  "unsupported type map[string]string",
+		"Any":   "unsupported type interface{}",
+		"Chan":  "unsupported type chan int",
+		"Func":  "unsupported type func()",
+		"Items": "unsupported type []map[string]int",
+		"At":    "unsupported type time.Time, it has no fields to set",
+		"Empty": "unsupported type struct{}, it has no fields to set",
+	}
+	for _, field := range paramFields(paramsStruct, pkg) {
+		got := ""
+		if err := checkField(field); err != nil {
+			got = err.Error()
+		}
+		if got != want[field.Name()] {
+			t.Errorf("%s: got %q, want %q", field.Name(), got, want[field.Name()])
+		}
+		delete(want, field.Name())
+	}
+	for name := range want {
+		t.Errorf("%s: not checked", name)
+	}
+}
+
+// typeCheck type-checks the source of a package named api.
+func typeCheck(t *testing.T, source string) *types.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "api.go", source, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("api", fset, []*ast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkg
+}
+
+// TestCheckFieldBounds makes sure that min and max bounds which would not
+// compile against the field type are reported.
+func TestCheckFieldBounds(t *testing.T) {
+	const source = "package api\n\ntype Params struct {\n" +
+		"\tSmall    uint8   `apivalidator:\"max=255\"`\n" +
+		"\tOverflow uint8   `apivalidator:\"max=300\"`\n" +
+		"\tUnsigned uint    `apivalidator:\"min=-1\"`\n" +
+		"\tFraction int     `apivalidator:\"min=1.5\"`\n" +
+		"\tSigned   int8    `apivalidator:\"min=-128,max=127\"`\n" +
+		"\tOver8    int8    `apivalidator:\"min=-129\"`\n" +
+		"\tWide     int64   `apivalidator:\"max=1e19\"`\n" +
+		"\tRatio    float64 `apivalidator:\"min=0.5\"`\n" +
+		"\tHuge     float32 `apivalidator:\"max=1e39\"`\n" +
+		"\tName     string  `apivalidator:\"min=2.5\"`\n" +
+		"\tLength   string  `apivalidator:\"min=-1\"`\n" +
+		"\tFlag     bool    `apivalidator:\"min=1\"`\n" +
+		"\tItems    []int8  `apivalidator:\"max=1000\"`\n" +
+		"}\n"
+	pkg := typeCheck(t, source)
+	paramsStruct := pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct)
+	want := map[string]string{
+		"Overflow": "apivalidator max=300 overflows uint8",
+		"Unsigned": "apivalidator min=-1 overflows uint",
+		"Fraction": "apivalidator min=1.5 is not a whole number",
+		"Over8":    "apivalidator min=-129 overflows int8",
+		"Wide":     "apivalidator max=10000000000000000000 overflows int64",
+		"Huge":     "apivalidator max=1000000000000000000000000000000000000000 overflows float32",
+		"Name":     "apivalidator min=2.5 is not a whole number",
+		"Length":   "apivalidator min=-1 is a negative length",
+		"Flag":     "apivalidator min does not apply to bool",
+		"Items":    "apivalidator max=1000 overflows int8",
+	}
+	for _, field := range paramFields(paramsStruct, pkg) {
+		got := ""
+		if err := checkField(field); err != nil {
+			got = err.Error()
+		}
+		if got != want[field.Name()] {
+			t.Errorf("%s: got %q, want %q", field.Name(), got, want[field.Name()])
+		}
+	}
+}
+
+func TestCheckDefault(t *testing.T) {
+	const source = "package api\n\ntype Params struct {\n" +
+		"\tFlag    bool     `apivalidator:\"default=yes\"`\n" +
+		"\tOn      bool     `apivalidator:\"default=true\"`\n" +
+		"\tSmall   int8     `apivalidator:\"default=200\"`\n" +
+		"\tCount   uint     `apivalidator:\"default=-1\"`\n" +
+		"\tWord    int      `apivalidator:\"default=ten\"`\n" +
+		"\tLow     int      `apivalidator:\"min=5,default=3\"`\n" +
+		"\tHigh    float64  `apivalidator:\"max=1.5,default=2\"`\n" +
+		"\tInRange int      `apivalidator:\"min=1,max=10,default=10\"`\n" +
+		"\tShort   string   `apivalidator:\"min=3,default=ab\"`\n" +
+		"\tCode    string   `apivalidator:\"len=2,default=abc\"`\n" +
+		"\tLetters string   `apivalidator:\"pattern=^[a-z]+$,default=a1\"`\n" +
+		"\tSize    int      `apivalidator:\"oneof=1|2|4,default=3\"`\n" +
+		"\tFits    int      `apivalidator:\"oneof=1|2|4,default=4\"`\n" +
+		"\tIDs     []int    `apivalidator:\"default=1\"`\n" +
+		"}\n"
+	pkg := typeCheck(t, source)
+	paramsStruct := pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct)
+	want := map[string]string{
+		"Flag":    `apivalidator default "yes" is not a bool`,
+		"Small":   `apivalidator default "200" is not int8`,
+		"Count":   `apivalidator default "-1" is not uint`,
+		"Word":    `apivalidator default "ten" is not int`,
+		"Low":     `apivalidator default "3" breaks min=5`,
+		"High":    `apivalidator default "2" breaks max=1.5`,
+		"Short":   `apivalidator default "ab" breaks min=3`,
+		"Code":    `apivalidator default "abc" breaks len=2`,
+		"Letters": `apivalidator default "a1" breaks pattern=^[a-z]+$`,
+		"Size":    `apivalidator default "3" is not one of oneof [1 2 4]`,
+		"IDs":     "apivalidator default is not supported for slices",
+	}
+	for _, field := range paramFields(paramsStruct, pkg) {
+		got := ""
+		if err := checkField(field); err != nil {
+			got = err.Error()
+		}
+		if got != want[field.Name()] {
+			t.Errorf("%s: got %q, want %q", field.Name(), got, want[field.Name()])
+		}
+	}
+}
+
+// TestOpenAPIFollowsRouter makes sure that every documented operation of a
+// url is the method the generated router calls for it.
+func TestOpenAPIFollowsRouter(t *testing.T) {
+	pkg := typeCheck(t, routingSource)
+	route := Route{Url: "/a", FuncData: []FuncData{
+		apiMethod(pkg, "Get", http.MethodGet),
+		apiMethod(pkg, "Do"),
+		apiMethod(pkg, "Put", http.MethodPut),
+	}}
+	spec := newOpenAPI(pkg).document("Api", route.FuncData)
+	item := spec["paths"].(Schema)["/a"].(Schema)
+
+	operations := make(map[string]string)
+	for method, operation := range item {
+		operations[method] = operation.(Schema)["operationId"].(string)
+	}
+	want := map[string]string{"get": "Get", "post": "Do", "put": "Put"}
+	if !reflect.DeepEqual(operations, want) {
+		t.Errorf("operations of /a are %v, want %v", operations, want)
+	}
+
+	switchData := routeSwitch(route, "nil")
+	for _, methodCase := range switchData.Cases {
+		if operation, ok := operations[strings.ToLower(methodCase.HTTPMethod)]; ok && operation != methodCase.Method {
+			t.Errorf("%s /a is routed to %s but documented as %s", methodCase.HTTPMethod, methodCase.Method, operation)
+		}
+	}
+}
+
+// TestOperationMethods checks the HTTP methods the clients call and the
+// OpenAPI document lists for each method of a url, and that the router
+// dispatches them to that method.
+func TestOperationMethods(t *testing.T) {
+	pkg := typeCheck(t, routingSource)
+	for _, test := range []struct {
+		name    string
+		methods []FuncData
+		want    map[string][]string
+		err     string
+	}{
+		{
+			name:    "catch-all takes post",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodGet), apiMethod(pkg, "Do"), apiMethod(pkg, "Put", http.MethodPut)},
+			want:    map[string][]string{"Get": {"GET"}, "Do": {"POST"}, "Put": {"PUT"}},
+		},
+		{
+			name:    "catch-all takes get and post",
+			methods: []FuncData{apiMethod(pkg, "Put", http.MethodPut, http.MethodPatch), apiMethod(pkg, "Do")},
+			want:    map[string][]string{"Put": {"PUT", "PATCH"}, "Do": {"GET", "POST"}},
+		},
+		{
+			name:    "catch-all falls back to put",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodPost, http.MethodGet), apiMethod(pkg, "Do")},
+			want:    map[string][]string{"Get": {"POST", "GET"}, "Do": {"PUT"}},
+		},
+		{
+			name:    "unreachable catch-all",
+			methods: []FuncData{apiMethod(pkg, "Get", anyMethods()...), apiMethod(pkg, "Do")},
+			want:    map[string][]string{"Get": anyMethods()},
+			err:     "method Do serves any method of /a, but the other methods serve GET, POST, PUT, PATCH, DELETE",
+		},
+	} {
+		route := Route{Url: "/a", FuncData: test.methods}
+		if err := checkRoute(route); test.err != "" || err != nil {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+		}
+		got := operationMethods(test.methods)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+
+		switchData := routeSwitch(route, "nil")
+		for name, methods := range got {
+			routed := switchData.CatchAll
+			for _, methodCase := range switchData.Cases {
+				if methodCase.HTTPMethod == methods[0] {
+					routed = methodCase.Method
+				}
+			}
+			if routed != name {
+				t.Errorf("%s: %s is called with %s, which is routed to %s", test.name, name, methods[0], routed)
+			}
+		}
+	}
+}
+
+// routingSource declares methods served on the same url.
+const routingSource = `package api
+
+import "context"
+
+type Api struct{}
+type Result struct{}
+
+func (a *Api) Get(ctx context.Context) (*Result, error) { return nil, nil }
+func (a *Api) Do(ctx context.Context) (*Result, error)  { return nil, nil }
+func (a *Api) Put(ctx context.Context) (*Result, error) { return nil, nil }
+`
+
+// TestRouteSwitch checks the HTTP methods a url is dispatched on, the Allow
+// header and the conflicts between methods sharing the url.
+func TestRouteSwitch(t *testing.T) {
+	pkg := typeCheck(t, routingSource)
+	for _, test := range []struct {
+		name    string
+		methods []FuncData
+		want    HandlersRoute
+		err     string
+	}{
+		{
+			name:    "head and options are answered",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodGet), apiMethod(pkg, "Put", http.MethodPut)},
+			want: HandlersRoute{
+				Cases:         []MethodCase{{"GET", "Get"}, {"HEAD", "Get"}, {"PUT", "Put"}},
+				Allow:         "GET, HEAD, OPTIONS, PUT",
+				AnswerOptions: true,
+			},
+		},
+		{
+			name:    "catch-all serves the rest",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodGet), apiMethod(pkg, "Do")},
+			want: HandlersRoute{
+				Cases:    []MethodCase{{"GET", "Get"}, {"HEAD", "Get"}},
+				CatchAll: "Do",
+				Allow:    "GET, HEAD",
+			},
+		},
+		{
+			name:    "explicit head and options",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodGet), apiMethod(pkg, "Put", http.MethodHead, http.MethodOptions)},
+			want: HandlersRoute{
+				Cases: []MethodCase{{"GET", "Get"}, {"HEAD", "Put"}, {"OPTIONS", "Put"}},
+				Allow: "GET, HEAD, OPTIONS",
+			},
+		},
+		{
+			name:    "only a catch-all",
+			methods: []FuncData{apiMethod(pkg, "Do")},
+			want:    HandlersRoute{CatchAll: "Do"},
+		},
+		{
+			name:    "same method twice",
+			methods: []FuncData{apiMethod(pkg, "Get", http.MethodGet), apiMethod(pkg, "Put", http.MethodPut, http.MethodGet)},
+			err:     "methods Get and Put both serve GET /a",
+		},
+		{
+			name:    "two catch-alls",
+			methods: []FuncData{apiMethod(pkg, "Do"), apiMethod(pkg, "Get")},
+			err:     "methods Do and Get both serve any method of /a",
+		},
+	} {
+		route := Route{Url: "/a", FuncData: test.methods}
+		if _, _, err := routeMethods(route); test.err != "" || err != nil {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		test.want.Url, test.want.PathArg = "/a", "nil"
+		if got := routeSwitch(route, "nil"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// apiMethod returns the FuncData of the method name of Api in pkg serving the
+// HTTP methods, all of them if none are given.
+func apiMethod(pkg *types.Package, name string, methods ...string) FuncData {
+	method, _, _ := types.LookupFieldOrMethod(types.NewPointer(pkg.Scope().Lookup("Api").Type()), false, pkg, name)
+	return FuncData{
+		RecvName:   "Api",
+		MethodName: name,
+		Signature:  method.Type().(*types.Signature),
+		Api:        Api{Url: "/a"},
+		Methods:    methods,
+	}
+}
+
+// TestImportAliases generates the handlers and the Go client of a package
+// whose params and result packages are named like a standard package the
+// generated code imports, like each other or unlike their path, and makes sure
+// that the generated files compile.
+func TestImportAliases(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "api_handlers.go")
+	clientOutput := filepath.Join(t.TempDir(), "apiclient", "client.go")
+	diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+	data := extractData("testdata/imports", output, diagnostics)
+	mapData := groupByStructLink(data)
+	checkPackage(data, mapData, diagnostics)
+	if diagnostics.Len() > 0 {
+		printed := new(bytes.Buffer)
+		diagnostics.Print(printed)
+		t.Fatalf("testdata/imports has problems:\n%s", printed)
+	}
+	writeRendered(t)(renderHandlers(data, mapData, output))
+	if err := os.Mkdir(filepath.Dir(clientOutput), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeRendered(t)(renderClient(data, mapData, clientOutput, ""))
+
+	for path, names := range map[string][]string{
+		"codegenhw/handlers_gen/testdata/imports": {"testdata/imports/api.go", output},
+		"apiclient": {clientOutput},
+	} {
+		fset := token.NewFileSet()
+		files := make([]*ast.File, 0, len(names))
+		for _, name := range names {
+			f, err := parser.ParseFile(fset, name, nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, f)
+		}
+		conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+		if _, err := conf.Check(path, fset, files, nil); err != nil {
+			t.Errorf("generated code of %s does not compile: %v", path, err)
+		}
+	}
+}
+
+// TestEncodeField makes sure that the Go client sends zero numbers and bools,
+// which the API would otherwise replace by the default or reject as missing.
+func TestEncodeField(t *testing.T) {
+	const source = "package api\n\ntype Params struct {\n" +
+		"\tAge    int     `apivalidator:\"default=5\"`\n" +
+		"\tActive bool    `apivalidator:\"default=true\"`\n" +
+		"\tName   string\n" +
+		"\tNick   *string\n" +
+		"\tLevel  int     `apivalidator:\"min=1\"`\n" +
+		"\tAdmin  bool\n" +
+		"\tScore  *float64 `apivalidator:\"min=1\"`\n" +
+		"}\n"
+	pkg := typeCheck(t, source)
+	paramsStruct := pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct)
+	want := map[string]string{
+		"Age":    `params.Set("age", strconv.FormatInt(int64(in.Age), 10))`,
+		"Active": `params.Set("active", strconv.FormatBool(bool(in.Active)))`,
+		"Name":   "if in.Name != \"\" {\n\t\tparams.Set(\"name\", string(in.Name))\n\t}",
+		"Nick":   "if in.Nick != nil {\nparams.Set(\"nick\", string(*in.Nick))\n}",
+		"Level":  "if in.Level != 0 {\n\t\tparams.Set(\"level\", strconv.FormatInt(int64(in.Level), 10))\n\t}",
+		"Admin":  "if in.Admin {\n\t\tparams.Set(\"admin\", strconv.FormatBool(bool(in.Admin)))\n\t}",
+		"Score":  "if in.Score != nil {\nparams.Set(\"score\", strconv.FormatFloat(float64(*in.Score), 'g', -1, 64))\n}",
+	}
+	for _, field := range paramFields(paramsStruct, pkg) {
+		if got := encodeField(field); got != want[field.Name()] {
+			t.Errorf("%s: got %q, want %q", field.Name(), got, want[field.Name()])
+		}
+	}
+}
+
+// TestParseValidatorTag checks the rules of an apivalidator tag, the escaping
+// of their values and the errors of invalid tags. The tags are quoted like in
+// Go source, where the backslash of \, is written twice.
+func TestParseValidatorTag(t *testing.T) {
+	for _, test := range []struct {
+		tag  string
+		want ValidatorArgs
+		err  string
+	}{
+		{tag: "", want: ValidatorArgs{}},
+		{
+			tag:  "required,paramname=full_name,min=1,max=10",
+			want: ValidatorArgs{Required: true, ParamName: "full_name", Min: 1, HasMin: true, Max: 10, HasMax: true},
+		},
+		{
+			tag:  `enum=a\,b|c\|d|e,default=a\,b`,
+			want: ValidatorArgs{Enum: Enum{Values: []string{"a,b", "c|d", "e"}}, HasEnum: true, Default: "a,b", HasDefault: true},
+		},
+		{
+			tag:  `pattern=^[a-z]\d{2\,3}$`,
+			want: ValidatorArgs{Pattern: `^[a-z]\d{2,3}$`},
+		},
+		{
+			tag:  "oneof=1|2|3,source=path",
+			want: ValidatorArgs{OneOf: []string{"1", "2", "3"}, Source: "path"},
+		},
+		{
+			tag:  "minitems=1,maxitems=3,csv",
+			want: ValidatorArgs{MinItems: 1, HasMinItems: true, MaxItems: 3, HasMaxItems: true, Csv: true},
+		},
+		{tag: "bogus", err: `unknown apivalidator key "bogus"`},
+		{tag: "min=1,min=2", err: `duplicate apivalidator key "min"`},
+		{tag: "min", err: `apivalidator key "min" needs a value`},
+		{tag: "required=true", err: `apivalidator key "required" takes no value`},
+		{tag: "min=zz", err: `apivalidator min must be a number, got "zz"`},
+		{tag: "min=5,max=1", err: "apivalidator min 5 is greater than max 1"},
+		{tag: "minitems=3,maxitems=1", err: "apivalidator minitems 3 is greater than maxitems 1"},
+		{tag: "minitems=-1", err: `apivalidator minitems must be a non-negative integer, got "-1"`},
+		{tag: "enum=a|b,default=c", err: `apivalidator default "c" is not one of enum [a b]`},
+		{tag: "required,default=a", err: "apivalidator default is never used by a required field"},
+		{tag: "source=query", err: `unknown apivalidator source "query"`},
+		{tag: "oneof=1|x", err: `apivalidator oneof takes integers, got "x"`},
+		{tag: "pattern=(", err: "bad apivalidator pattern: error parsing regexp: missing closing ): `(`"},
+	} {
+		args, err := parseValidatorTag("apivalidator:" + strconv.Quote(test.tag))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: got error %v, want %q", test.tag, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.tag, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, test.want) {
+			t.Errorf("%q: got %+v, want %+v", test.tag, args, test.want)
+		}
+	}
+}
+
+// TestSplitEscaped checks that only an escaped separator is unescaped.
+func TestSplitEscaped(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		sep   byte
+		want  []string
+	}{
+		{"", ',', []string{""}},
+		{"a,b", ',', []string{"a", "b"}},
+		{`a\,b,c`, ',', []string{"a,b", "c"}},
+		{`a\|b|c`, '|', []string{"a|b", "c"}},
+		{`a\,b|c`, '|', []string{`a\,b`, "c"}},
+		{`\d+,x\`, ',', []string{`\d+`, `x\`}},
+		{"a,,b,", ',', []string{"a", "", "b", ""}},
+	} {
+		if got := splitEscaped(test.value, test.sep); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitEscaped(%q, %q) = %q, want %q", test.value, test.sep, got, test.want)
+		}
+	}
+}
+
+// TestParseValidatorTagQuoting makes sure that a struct tag reflect can't
+// unquote is reported instead of losing its rules.
+func TestParseValidatorTagQuoting(t *testing.T) {
+	args, err := parseValidatorTag(`json:"name" apivalidator:"required,pattern=^\\d+$"`)
+	if err != nil || !args.Required || args.Pattern != `^\d+$` {
+		t.Errorf("doubled backslash: got %+v, %v", args, err)
+	}
+	want := `apivalidator tag is not a valid quoted string, write a backslash as \\`
+	if _, err := parseValidatorTag(`json:"name" apivalidator:"required,pattern=^\d+$"`); err == nil || err.Error() != want {
+		t.Errorf("single backslash: got error %v, want %q", err, want)
+	}
+}
+
+// TestFeatures generates the handlers of the packages under testdata that
+// exercise request bodies, routing and params binding, and runs their tests
+// against the generated code in a module of their own.
+func TestFeatures(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test on the generated code")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go tool")
+	}
+
+	// with -fielderrors every 400 response changes, so that run is limited to
+	// the tests with the fielderrors build tag. The go directive of go.mod is
+	// the one of the repository, or goVersion, as Go 1.21 modules check enum
+	// and oneof values with slices.Contains.
+	for _, pkg := range []struct {
+		dir         string
+		fieldErrors bool
+		goVersion   string
+	}{
+		{dir: "testdata/features"},
+		{dir: "testdata/features", fieldErrors: true},
+		{dir: "testdata/features", goVersion: "1.21"},
+	} {
+		name := filepath.Base(pkg.dir)
+		if pkg.fieldErrors {
+			name += "-fielderrors"
+		}
+		if pkg.goVersion == "" {
+			pkg.goVersion = moduleGoVersion("..")
+		} else {
+			name += "-go" + pkg.goVersion
+		}
+		t.Run(name, func(t *testing.T) {
+			module := t.TempDir()
+			err := filepath.WalkDir(pkg.dir, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				target := filepath.Join(module, strings.TrimPrefix(path, pkg.dir))
+				if entry.IsDir() {
+					return os.MkdirAll(target, 0755)
+				}
+				content, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				return os.WriteFile(target, content, 0644)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			goMod := "module " + filepath.Base(pkg.dir) + "\n\ngo " + pkg.goVersion + "\n"
+			if err := os.WriteFile(filepath.Join(module, "go.mod"), []byte(goMod), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			defer func(fields bool, header string, status int) {
+				*fieldErrors, *authHeader, *authStatus = fields, header, status
+			}(*fieldErrors, *authHeader, *authStatus)
+			*fieldErrors = pkg.fieldErrors
+			// not the defaults, so that auth_test.go sees them used
+			*authHeader, *authStatus = "X-Token", http.StatusUnauthorized
+			output := filepath.Join(module, "api_handlers.go")
+			diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+			data := extractData(module, output, diagnostics)
+			mapData := groupByStructLink(data)
+			checkPackage(data, mapData, diagnostics)
+			if diagnostics.Len() > 0 {
+				printed := new(bytes.Buffer)
+				diagnostics.Print(printed)
+				t.Fatalf("%s has problems:\n%s", pkg.dir, printed)
+			}
+			writeRendered(t)(renderHandlers(data, mapData, output))
+			writeRendered(t)(renderClient(data, mapData, filepath.Join(module, "client", "client.go"), ""))
+
+			args := []string{"test", "-count=1"}
+			if pkg.fieldErrors {
+				args = append(args, "-tags", "fielderrors")
+			}
+			cmd := exec.Command(goTool, append(args, "./...")...)
+			cmd.Dir = module
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("go test of %s failed: %v\n%s", pkg.dir, err, out)
+			}
+		})
+	}
+}
+
+// TestCheckPathFields makes sure that url placeholders and path fields are
+// bound one to one.
+func TestCheckPathFields(t *testing.T) {
+	const source = "package api\n\ntype Params struct {\n" +
+		"\tLogin string `apivalidator:\"source=path\"`\n" +
+		"\tID    int    `apivalidator:\"source=path,paramname=post_id\"`\n" +
+		"\tQuery string\n" +
+		"}\n"
+	pkg := typeCheck(t, source)
+	fields := paramFields(pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct), pkg)
+	for url, want := range map[string][]string{
+		"/user/{login}/posts/{post_id}": nil,
+		"/user/{login}/posts/{id}": {
+			"field ID of Post is bound to {post_id} missing in url /user/{login}/posts/{id}",
+			"{id} of url /user/{login}/posts/{id} is not bound to a field with source=path",
+		},
+		"/user/{login}/{post_id}/{query}": {
+			"{query} of url /user/{login}/{post_id}/{query} is not bound to a field with source=path",
+		},
+	} {
+		funcData := FuncData{MethodName: "Post", Api: Api{Url: url}}
+		got := make([]string, 0)
+		for _, err := range checkPathFields(funcData, fields) {
+			got = append(got, err.Error())
+		}
+		if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", url, got, want)
+		}
+	}
+}
+
+// TestParamFields checks the selectors, param names and pointer structs of
+// the fields of nested, embedded and pointer structs.
+func TestParamFields(t *testing.T) {
+	const source = "package api\n\n" +
+		"type Paging struct{ Limit int }\n" +
+		"type Geo struct{ Lat float64 }\n" +
+		"type Address struct {\n\tCity string\n\tGeo  *Geo `json:\"geo\"`\n}\n" +
+		"type Node struct {\n\tName string\n\tNext *Node\n}\n" +
+		"type Params struct {\n" +
+		"\t*Paging\n" +
+		"\tName    *string\n" +
+		"\tAddress *Address `apivalidator:\"paramname=addr\"`\n" +
+		"\tNode    Node\n" +
+		"\thidden  int\n" +
+		"\tAddressCity  string\n" +
+		"\tAddress_City string\n" +
+		"\tNode_Name    string `apivalidator:\"paramname=node.name\"`\n" +
+		"}\n"
+	pkg := typeCheck(t, source)
+	paramsStruct := pkg.Scope().Lookup("Params").Type().Underlying().(*types.Struct)
+
+	type field struct {
+		Path      string
+		Param     string
+		Ident     string
+		Pointers  string
+		IsPointer bool
+	}
+	want := []field{
+		{Path: "Paging.Limit", Param: "limit", Ident: "Paging_Limit", Pointers: "Paging"},
+		{Path: "Name", Param: "name", Ident: "Name", IsPointer: true},
+		{Path: "Address.City", Param: "addr.city", Ident: "Address_City", Pointers: "Address"},
+		{Path: "Address.Geo.Lat", Param: "addr.geo.lat", Ident: "Address_Geo_Lat", Pointers: "Address Address.Geo"},
+		{Path: "Node.Name", Param: "node.name", Ident: "Node_Name"},
+		// a recursive struct is a leaf, which checkField reports
+		{Path: "Node.Next", Param: "node.next", Ident: "Node_Next", IsPointer: true},
+		{Path: "hidden", Param: "hidden", Ident: "hidden"},
+		{Path: "AddressCity", Param: "addresscity", Ident: "AddressCity"},
+		{Path: "Address_City", Param: "address_city", Ident: "Address_0City"},
+		{Path: "Node_Name", Param: "node.name", Ident: "Node_0Name"},
+	}
+	got := make([]field, 0)
+	for _, paramField := range paramFields(paramsStruct, pkg) {
+		pointers := make([]string, 0, len(paramField.Pointers))
+		for _, pointer := range paramField.Pointers {
+			pointers = append(pointers, pointer.Path)
+		}
+		got = append(got, field{
+			Path:      paramField.Path,
+			Param:     paramName(paramField, parseValidatorArgs(paramField.Tag)),
+			Ident:     paramField.ident(),
+			Pointers:  strings.Join(pointers, " "),
+			IsPointer: paramField.IsPointer,
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got fields\n%+v\nwant\n%+v", got, want)
+	}
+
+	errs := checkDuplicateFields(FuncData{MethodName: "Get"}, paramFields(paramsStruct, pkg))
+	wantErr := "fields Node.Name and Node_Name of Get both read param node.name"
+	if len(errs) != 1 || errs[0].Error() != wantErr {
+		t.Errorf("got duplicate errors %q, want %q", errs, wantErr)
+	}
+}
+
+// TestErrorTargets checks the error types errorStatus looks for and the
+// problems of an error type reported for an explicit -errortype.
+func TestErrorTargets(t *testing.T) {
+	const source = "package api\n\n" +
+		"type ValueError struct{ HTTPStatus int }\n" +
+		"func (e ValueError) Error() string { return \"\" }\n" +
+		"type PointerError struct{ HTTPStatus int }\n" +
+		"func (e *PointerError) Error() string { return \"\" }\n" +
+		"type NoStatus struct{ Status int }\n" +
+		"func (e NoStatus) Error() string { return \"\" }\n" +
+		"type NoError struct{ HTTPStatus int }\n"
+	pkg := typeCheck(t, source)
+	for _, test := range []struct {
+		name    string
+		value   string
+		pointer string
+		err     string
+	}{
+		{name: "ValueError", value: "ValueError", pointer: "*ValueError"},
+		{name: "PointerError", pointer: "*PointerError"},
+		{name: "NoStatus", err: "error type NoStatus has no HTTPStatus int field"},
+		{name: "NoError", err: "error type NoError does not implement error"},
+		{name: "Missing", err: "error type Missing is not declared in package api"},
+	} {
+		value, pointer, err := errorTargets(pkg, test.name)
+		if test.err != "" || err != nil {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		qualifier := func(*types.Package) string { return "" }
+		gotValue := ""
+		if value != nil {
+			gotValue = types.TypeString(value, qualifier)
+		}
+		if gotPointer := types.TypeString(pointer, qualifier); gotValue != test.value || gotPointer != test.pointer {
+			t.Errorf("%s: got targets %q and %q, want %q and %q", test.name, gotValue, gotPointer, test.value, test.pointer)
+		}
+	}
+}
+
+// writeRendered writes an output a render function returns, failing the test
+// on an error.
+func writeRendered(t *testing.T) func(Output, error) {
+	return func(output Output, err error) {
+		t.Helper()
+		if err == nil {
+			err = writeOutputs([]Output{output})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestWriteOutputs makes sure that no output is written when one of them
+// can't be.
+func TestWriteOutputs(t *testing.T) {
+	dir := t.TempDir()
+	handlers := Output{Path: filepath.Join(dir, "api_handlers.go"), Content: []byte("package api\n")}
+	client := Output{Path: filepath.Join(dir, "client", "client.go"), Content: []byte("package client\n"), Kind: "Client"}
+	if err := writeOutputs([]Output{handlers, client}); err == nil {
+		t.Fatal("no error for a missing directory")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("files left after a failure: %v", entries)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "client"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutputs([]Output{handlers, client}); err != nil {
+		t.Fatal(err)
+	}
+	for _, output := range []Output{handlers, client} {
+		content, err := os.ReadFile(output.Path)
+		if err != nil || string(content) != string(output.Content) {
+			t.Errorf("%s: got %q, %v, want %q", output.Path, content, err, output.Content)
+		}
+	}
+}
+
+// checkSource runs checkPackage on a package of a single file and returns the
+// printed diagnostics.
+func checkSource(t *testing.T, source string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "api.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := &Diagnostics{Fset: token.NewFileSet()}
+	data := extractData(dir, filepath.Join(dir, "api_handlers.go"), diagnostics)
+	if diagnostics.Len() == 0 {
+		checkPackage(data, groupByStructLink(data), diagnostics)
+	}
+	printed := new(bytes.Buffer)
+	diagnostics.Print(printed)
+	lines := strings.Split(strings.TrimSpace(printed.String()), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, filepath.Join(dir, "api.go")+":")
+	}
+	if lines[0] == "" {
+		return nil
+	}
+	return lines
+}
+
+// TestCheckResolvers makes sure that roles and minStatus are only generated
+// for an API struct resolving them.
+func TestCheckResolvers(t *testing.T) {
+	const source = `package api
+
+import (
+	"context"
+	"net/http"
+)
+
+type Api struct{}
+type Result struct{}
+
+// apigen:api {"url": "/admin", "roles": ["admin"]}
+func (a *Api) Admin(ctx context.Context) (*Result, error) { return nil, nil }
+
+// apigen:api {"url": "/vip", "minStatus": 10}
+func (a *Api) Vip(ctx context.Context) (*Result, error) { return nil, nil }
+
+type Other struct{}
+
+func (o *Other) ResolveRoles(ctx context.Context) ([]string, error) { return nil, nil }
+func (o *Other) ResolveStatus(ctx context.Context, r *http.Request) (int, error) { return 0, nil }
+
+// apigen:api {"url": "/other", "roles": ["admin"], "minStatus": 1}
+func (o *Other) Get(ctx context.Context) (*Result, error) { return nil, nil }
+`
+	want := []string{
+		"11:1: Api must implement ResolveRoles(context.Context, *http.Request) ([]string, error) for roles of Admin",
+		"14:1: Api must implement ResolveStatus(context.Context, *http.Request) (int, error) for minStatus of Vip",
+		"19:17: Other.ResolveRoles must be ResolveRoles(context.Context, *http.Request) ([]string, error)",
+	}
+	if got := checkSource(t, source); !reflect.DeepEqual(got, want) {
+		t.Errorf("got diagnostics\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io"
+	"sort"
+)
+
+// maxDiagnostics is the number of problems printed before the rest is
+// summarized as "too many errors", like the Go compiler does.
+const maxDiagnostics = 10
+
+// Diagnostic is a problem of the package the code is generated for.
+type Diagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if !d.Pos.IsValid() {
+		return d.Message
+	}
+	return d.Pos.String() + ": " + d.Message
+}
+
+// Diagnostics collects every problem found in the package before any code is
+// generated, so that they are reported at once and no output is written.
+type Diagnostics struct {
+	Fset *token.FileSet
+	list []Diagnostic
+}
+
+// posError is an error at a position of the package, see errorAt.
+type posError struct {
+	pos token.Pos
+	err error
+}
+
+func (e posError) Error() string {
+	return e.err.Error()
+}
+
+// errorAt returns an error that Diagnostics.Add reports at pos.
+func errorAt(pos token.Pos, format string, args ...interface{}) error {
+	return posError{pos: pos, err: fmt.Errorf(format, args...)}
+}
+
+// Errorf records a problem at pos.
+func (d *Diagnostics) Errorf(pos token.Pos, format string, args ...interface{}) {
+	d.list = append(d.list, Diagnostic{Pos: d.Fset.Position(pos), Message: fmt.Sprintf(format, args...)})
+}
+
+// Add records err at its position: the one of errorAt, every position of a
+// scanner.ErrorList, or none.
+func (d *Diagnostics) Add(err error) {
+	var list scanner.ErrorList
+	if errors.As(err, &list) {
+		for _, scanErr := range list {
+			d.list = append(d.list, Diagnostic{Pos: scanErr.Pos, Message: scanErr.Msg})
+		}
+		return
+	}
+	var atErr posError
+	if errors.As(err, &atErr) {
+		d.Errorf(atErr.pos, "%s", err.Error())
+		return
+	}
+	d.list = append(d.list, Diagnostic{Message: err.Error()})
+}
+
+func (d *Diagnostics) Len() int {
+	return len(d.list)
+}
+
+// Print writes the problems sorted by position, one per line.
+func (d *Diagnostics) Print(w io.Writer) {
+	sort.SliceStable(d.list, func(i, j int) bool {
+		a, b := d.list[i].Pos, d.list[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	printed := 0
+	for i, diagnostic := range d.list {
+		if i > 0 && diagnostic == d.list[i-1] {
+			continue
+		}
+		if printed == maxDiagnostics {
+			fmt.Fprintln(w, "too many errors")
+			return
+		}
+		fmt.Fprintln(w, diagnostic)
+		printed++
+	}
+}
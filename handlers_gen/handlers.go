@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// HandlersData is passed to handlersTpl to render the handlers file.
+type HandlersData struct {
+	PackageName string
+	Imports     []ImportSpec
+	Apis        []HandlersApi
+	// Helpers are the shared functions the handlers refer to, see helpers
+	Helpers []string
+	// ErrorTarget and ErrorPointer are the error type and the pointer to it
+	// errorStatus looks for, see errorTargets
+	ErrorTarget  string
+	ErrorPointer string
+	Patterns     []string
+	// UsesPrincipal is set when an API struct has an Authenticate method
+	UsesPrincipal bool
+}
+
+// HandlersApi is ServeHTTP, the handlers and the params conversions of a
+// single API struct.
+type HandlersApi struct {
+	Name     string
+	Static   []HandlersRoute
+	Patterns []HandlersRoute
+	Methods  []HandlersMethod
+}
+
+// HandlersRoute dispatches the requests of a url by their HTTP method, see
+// routeSwitch.
+type HandlersRoute struct {
+	Url     string
+	PathArg string
+	Cases   []MethodCase
+	// CatchAll is the method serving the HTTP methods without a case
+	CatchAll string
+	// Allow lists the HTTP methods of the route for OPTIONS and 405
+	Allow         string
+	AnswerOptions bool
+}
+
+// MethodCase serves an HTTP method by an API method.
+type MethodCase struct {
+	HTTPMethod string
+	Method     string
+}
+
+// GoMethod is the HTTP method as the generated code compares it.
+func (c MethodCase) GoMethod() string {
+	return goMethods([]string{c.HTTPMethod})
+}
+
+// HandlersMethod is the handler of an API method and the conversion of its params.
+type HandlersMethod struct {
+	Api  string
+	Name string
+	// Auth is "authenticate" when the API struct authenticates the request,
+	// "header" when only the auth header is checked, empty otherwise
+	Auth         string
+	AuthHeader   string
+	AuthStatus   string
+	Roles        string
+	MinStatus    int
+	HasMinStatus bool
+	Timeout      time.Duration
+	// ResultType and ParamsType are empty if the method returns only an
+	// error or takes no params
+	ResultType  string
+	ParamsType  string
+	ParamsArg   string
+	FieldErrors bool
+	Fields      []FieldConversion
+	Assignments []FieldAssignment
+}
+
+// FieldConversion reads a single params field and validates it, see
+// fieldConversion.
+type FieldConversion struct {
+	IsSlice   bool
+	Var       string
+	StringVar string
+	ParsedVar string
+	// Source is the expression reading the raw value, all the values for
+	// a slice field
+	Source     string
+	Csv        bool
+	HasDefault bool
+	Default    string
+	// Basic is the basic type a value is parsed into, ItemType the element
+	// type of a slice field
+	Basic    string
+	ItemType string
+	// Parse is the strconv call parsing a value, empty for strings
+	Parse    string
+	TypeFail string
+	// Presence are the checks of the raw value, Checks the ones of a sent
+	// value once it is parsed
+	Presence []Check
+	Checks   []Check
+}
+
+// Check rejects a value by Fail when Cond holds.
+type Check struct {
+	Cond string
+	Fail string
+}
+
+// FieldAssignment sets a converted field of the params struct, see
+// fieldAssignment.
+type FieldAssignment struct {
+	Path  string
+	Value string
+	// Sent is the condition the assignment is limited to, empty if there is
+	// none: the presence of the param for pointers
+	Sent     string
+	Pointers []PointerAllocation
+	// ValueVar holds the value a pointer field points to
+	ValueVar string
+}
+
+// PointerAllocation allocates a pointer struct before one of its fields is set.
+type PointerAllocation struct {
+	Path string
+	Type string
+}
+
+// ImportSpec is an import of the handlers file, Name is the alias of a
+// package whose name is taken by another import.
+type ImportSpec struct {
+	Name string
+	Path string
+}
+
+var handlersTpl = template.Must(template.New("handlersTpl").Parse(`// Code generated by handlers_gen. DO NOT EDIT.
+
+package {{.PackageName}}
+{{- if .Imports}}
+
+import (
+{{- range .Imports}}
+	{{if .Name}}{{.Name}} {{end}}"{{.Path}}"
+{{- end}}
+)
+{{- end}}
+{{range .Apis}}{{template "router" .}}{{range .Methods}}{{template "handler" .}}{{end}}{{range .Methods}}{{if .ParamsType}}{{template "convert" .}}{{end}}{{end}}{{end}}
+{{- range .Helpers}}{{.}}{{end}}
+{{- /* errorStatus maps an error returned by an API method to the response
+	status. The status is taken from the first error in the chain that is of
+	the named error type or a pointer to it with an int HTTPStatus field, or
+	that implements HTTPStatus() int; fallback otherwise. */}}
+
+func errorStatus(err error, fallback int) int {
+{{- if .ErrorTarget}}
+	var apiError {{.ErrorTarget}}
+	if errors.As(err, &apiError) {
+		return apiError.HTTPStatus
+	}
+{{- end}}
+{{- if .ErrorPointer}}
+	var apiErrorPointer {{.ErrorPointer}}
+	if errors.As(err, &apiErrorPointer) && apiErrorPointer != nil {
+		return apiErrorPointer.HTTPStatus
+	}
+{{- end}}
+	var statusError interface{ HTTPStatus() int }
+	if errors.As(err, &statusError) {
+		return statusError.HTTPStatus()
+	}
+	return fallback
+}
+{{- if .Patterns}}
+
+var (
+{{- range $i, $pattern := .Patterns}}
+	validatorPattern{{$i}} = regexp.MustCompile({{printf "%q" $pattern}})
+{{- end}}
+)
+{{- end}}
+{{- if .UsesPrincipal}}
+
+// principalKey is the context key the principal returned by Authenticate
+// is stored under for the API method.
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) interface{} {
+	return ctx.Value(principalKey{})
+}
+{{- end}}
+{{define "router"}}
+
+func (h *{{.Name}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+{{- range .Static}}
+	case {{printf "%q" .Url}}:{{template "methodSwitch" .}}
+{{- end}}
+	default:
+{{- range .Patterns}}
+		if pathParams, ok := matchPath({{printf "%q" .Url}}, r.URL.EscapedPath()); ok { {{- template "methodSwitch" .}}
+			return
+		}
+{{- end}}
+		putError(w, "unknown method", http.StatusNotFound)
+	}
+}
+{{end}}
+{{- define "methodSwitch"}}
+{{- if not .Cases}}
+	h.handler{{.CatchAll}}(w, r, {{.PathArg}})
+{{- else}}
+	switch r.Method {
+{{- range .Cases}}
+	case {{.GoMethod}}:
+		h.handler{{.Method}}(w, r, {{$.PathArg}})
+{{- end}}
+{{- if .AnswerOptions}}
+	case http.MethodOptions:
+		w.Header().Set("Allow", {{printf "%q" .Allow}})
+		w.WriteHeader(http.StatusNoContent)
+{{- end}}
+	default:
+{{- if .CatchAll}}
+		h.handler{{.CatchAll}}(w, r, {{.PathArg}})
+{{- else}}
+		methodNotAllowed(w, {{printf "%q" .Allow}})
+{{- end}}
+	}
+{{- end}}
+{{- end}}
+{{- define "handler"}}
+
+func (h *{{.Api}}) handler{{.Name}}(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+{{- if eq .Auth "authenticate"}}
+	principal, authError := h.Authenticate(r.Context(), r)
+	if authError != nil {
+		putError(w, authError.Error(), errorStatus(authError, {{.AuthStatus}}))
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+{{- else if eq .Auth "header"}}
+	authToken := r.Header.Get({{printf "%q" .AuthHeader}})
+	if authToken == "" {
+		putError(w, "unauthorized", {{.AuthStatus}})
+		return
+	}
+{{- end}}
+{{- if .Roles}}
+	roles, rolesError := h.ResolveRoles(r.Context(), r)
+	if rolesError != nil {
+		putError(w, rolesError.Error(), errorStatus(rolesError, http.StatusForbidden))
+		return
+	}
+	if !hasAnyRole(roles, {{.Roles}}) {
+		putError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+{{- end}}
+{{- if .HasMinStatus}}
+	status, statusError := h.ResolveStatus(r.Context(), r)
+	if statusError != nil {
+		putError(w, statusError.Error(), errorStatus(statusError, http.StatusForbidden))
+		return
+	}
+	if status < {{.MinStatus}} {
+		putError(w, "forbidden", http.StatusForbidden)
+		return
+	}
+{{- end}}
+{{- if .ParamsType}}
+	params, paramsError := readParams(r)
+	if paramsError != nil {
+		putError(w, "bad params: "+paramsError.Error(), http.StatusBadRequest)
+		return
+	}
+	converted, error := convertFor{{.Api}}{{.Name}}(params, pathParams)
+	if error != nil {
+{{- if .FieldErrors}}
+		putValidationError(w, error)
+{{- else}}
+		putError(w, error.Error(), http.StatusBadRequest)
+{{- end}}
+		return
+	}
+{{- else if .Timeout}}
+	var error error
+{{- end}}
+{{- if .Timeout}}
+{{- /* the method runs in its own goroutine so that the response is sent
+	when the deadline expires even if the method does not watch the context.
+	net/http recovers panics of the handler goroutine only, so a panic of the
+	method is passed back and raised again by the handler */}}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration({{printf "%d" .Timeout}})) // {{.Timeout}}
+	defer cancel()
+{{- if .ResultType}}
+	var res {{.ResultType}}
+{{- end}}
+	var panicked interface{}
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			panicked = recover()
+			close(done)
+		}()
+		{{if .ResultType}}res, {{end}}error = h.{{.Name}}(ctx{{if .ParamsType}}, {{.ParamsArg}}{{end}})
+	}()
+	select {
+	case <-done:
+		if panicked != nil {
+			panic(panicked)
+		}
+	case <-ctx.Done():
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		putError(w, "timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+{{- else}}
+{{- if .ResultType}}
+	res, error := h.{{.Name}}(r.Context(){{if .ParamsType}}, {{.ParamsArg}}{{end}})
+{{- else}}
+	error {{if .ParamsType}}={{else}}:={{end}} h.{{.Name}}(r.Context(){{if .ParamsType}}, {{.ParamsArg}}{{end}})
+{{- end}}
+{{- end}}
+	if error != nil {
+		putError(w, error.Error(), errorStatus(error, http.StatusInternalServerError))
+		return
+	}
+{{- if .ResultType}}
+	w.Write(putRes(res))
+{{- else}}
+	w.Write(putRes(nil))
+{{- end}}
+}
+{{end}}
+{{- define "convert"}}
+
+func convertFor{{.Api}}{{.Name}}(params url.Values, pathParams map[string]string) ({{.ParamsType}}, error) {
+{{- if .FieldErrors}}
+	validation := &validationError{}
+{{- end}}
+{{- range .Fields}}{{if .IsSlice}}{{template "sliceField" .}}{{else}}{{template "field" .}}{{end}}{{end}}
+{{- if .FieldErrors}}
+	if len(validation.fields) > 0 {
+		return {{.ParamsType}}{}, validation
+	}
+{{- end}}
+	var converted {{.ParamsType}}
+{{- range .Assignments}}{{template "assignment" .}}{{end}}
+	return converted, nil
+}
+{{end}}
+{{- define "field"}}
+{{- if .Parse}}
+	var {{.Var}} {{.Basic}}
+{{- end}}
+	{{.StringVar}} := {{.Source}}
+{{- if .HasDefault}}
+	if {{.StringVar}} == "" {
+		{{.StringVar}} = {{printf "%q" .Default}}
+	}
+{{- end}}
+{{- range .Presence}}{{template "check" .}}{{end}}
+{{- if or .Parse .Checks}}
+	if {{.StringVar}} != "" {
+{{- if .Parse}}
+		{{.ParsedVar}}, err := {{.Parse}}
+		if err != nil {
+			{{.TypeFail}}
+		}
+		{{.Var}} = {{.Basic}}({{.ParsedVar}})
+{{- end}}
+{{- range .Checks}}{{template "check" .}}{{end}}
+	}
+{{- end}}
+{{- end}}
+{{- define "sliceField"}}
+	{{.StringVar}} := {{.Source}}
+{{- if .Csv}}
+	{{.StringVar}} = splitValues({{.StringVar}})
+{{- end}}
+{{- range .Presence}}{{template "check" .}}{{end}}
+	{{.Var}} := make([]{{.ItemType}}, 0, len({{.StringVar}}))
+	for _, stringItem := range {{.StringVar}} {
+{{- if .Parse}}
+		parsedItem, err := {{.Parse}}
+		if err != nil {
+			{{.TypeFail}}
+		}
+		item := {{.Basic}}(parsedItem)
+{{- else}}
+		item := stringItem
+{{- end}}
+{{- range .Checks}}{{template "check" .}}{{end}}
+		{{.Var}} = append({{.Var}}, {{.ItemType}}(item))
+	}
+{{- end}}
+{{- define "check"}}
+	if {{.Cond}} {
+		{{.Fail}}
+	}
+{{- end}}
+{{- define "assignment"}}
+{{- if .Sent}}
+	if {{.Sent}} {
+{{- template "set" .}}
+	}
+{{- else}}
+{{- template "set" .}}
+{{- end}}
+{{- end}}
+{{- define "set"}}
+{{- range .Pointers}}
+	if converted.{{.Path}} == nil {
+		converted.{{.Path}} = &{{.Type}}{}
+	}
+{{- end}}
+{{- if .ValueVar}}
+	{{.ValueVar}} := {{.Value}}
+	converted.{{.Path}} = &{{.ValueVar}}
+{{- else}}
+	converted.{{.Path}} = {{.Value}}
+{{- end}}
+{{- end}}`))
+
+// standardImports are the packages the generated code may refer to, besides
+// the ones of the params and result types.
+var standardImports = []string{"context", "encoding/json", "errors", "io", "mime", "net/http", "net/mail", "net/url", "regexp", "slices", "strconv", "strings", "time"}
+
+// renderHandlers renders ServeHTTP, the handlers and the params conversions of
+// every API struct of the package into output. The shared helpers and the
+// imports are limited to the ones the code refers to, and the file is gofmt'd.
+func renderHandlers(data PackageData, mapData map[string][]FuncData, output string) (Output, error) {
+	imports := newImportSet(data.Package, standardImports)
+	qualifier := imports.qualifier
+
+	patterns = nil
+	inlineContains = !goVersionAtLeast(data.GoVersion, 21)
+
+	handlersData := HandlersData{PackageName: data.PackageName}
+	for _, recvName := range sortedKeys(mapData) {
+		if authenticator(data.Package, recvName) != nil {
+			handlersData.UsesPrincipal = true
+		}
+		handlersData.Apis = append(handlersData.Apis, apiHandlers(data.Package, recvName, mapData[recvName], qualifier))
+	}
+	if value, pointer, err := errorTargets(data.Package, *errorType); err == nil {
+		if value != nil {
+			handlersData.ErrorTarget = types.TypeString(value, qualifier)
+		}
+		handlersData.ErrorPointer = types.TypeString(pointer, qualifier)
+	} else {
+		fmt.Println("Error type", *errorType, "with HTTPStatus field not found, only HTTPStatus() int is used")
+	}
+	handlersData.Patterns = patterns
+
+	// helpers do not refer to other helpers the handlers may not use, so
+	// that a single pass over the handlers is enough
+	body, err := executeHandlers(handlersData)
+	if err != nil {
+		return Output{}, err
+	}
+	used := unresolvedNames(body)
+	for _, helper := range helpers {
+		if used[helper.name] {
+			handlersData.Helpers = append(handlersData.Helpers, helper.code)
+		}
+	}
+	if body, err = executeHandlers(handlersData); err != nil {
+		return Output{}, err
+	}
+	handlersData.Imports = imports.used(body)
+
+	if body, err = executeHandlers(handlersData); err != nil {
+		return Output{}, err
+	}
+	source, err := format.Source(body)
+	if err != nil {
+		return Output{}, fmt.Errorf("generated code is malformed: %w", err)
+	}
+	return Output{Path: output, Content: source}, nil
+}
+
+// importSet names the packages a generated file may import: the standard ones
+// it refers to and the packages of the params and result types. A package
+// whose name is taken by another import or a declaration of the API package
+// is imported as name2, name3, ...
+type importSet struct {
+	pkg   *types.Package
+	names map[string]ImportSpec
+}
+
+func newImportSet(pkg *types.Package, standard []string) *importSet {
+	imports := &importSet{pkg: pkg, names: make(map[string]ImportSpec)}
+	for _, path := range standard {
+		imports.names[path[strings.LastIndex(path, "/")+1:]] = ImportSpec{Path: path}
+	}
+	return imports
+}
+
+// qualifier is the types.Qualifier of the generated code, it names the
+// packages of the types in the file.
+func (s *importSet) qualifier(pkg *types.Package) string {
+	if pkg == s.pkg {
+		return ""
+	}
+	name := pkg.Name()
+	for i := 2; ; i++ {
+		if imported, taken := s.names[name]; imported.Path == pkg.Path() {
+			return name
+		} else if !taken && s.pkg.Scope().Lookup(name) == nil {
+			break
+		}
+		name = pkg.Name() + strconv.Itoa(i)
+	}
+	spec := ImportSpec{Path: pkg.Path()}
+	if name != pkg.Name() {
+		spec.Name = name
+	}
+	s.names[name] = spec
+	return name
+}
+
+// used returns the imports the source refers to, sorted by path.
+func (s *importSet) used(source []byte) []ImportSpec {
+	names := unresolvedNames(source)
+	imports := make([]ImportSpec, 0, len(names))
+	for name, spec := range s.names {
+		if names[name] {
+			imports = append(imports, spec)
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path < imports[j].Path
+	})
+	return imports
+}
+
+func executeHandlers(handlersData HandlersData) ([]byte, error) {
+	body := new(bytes.Buffer)
+	err := handlersTpl.Execute(body, handlersData)
+	return body.Bytes(), err
+}
+
+// unresolvedNames returns the names the generated code refers to without
+// declaring them: the packages to import and the helpers not written yet.
+// Malformed code refers to nothing, format.Source reports it afterwards.
+func unresolvedNames(source []byte) map[string]bool {
+	names := make(map[string]bool)
+	file, err := parser.ParseFile(token.NewFileSet(), "", source, 0)
+	if err != nil {
+		return names
+	}
+	for _, ident := range file.Unresolved {
+		names[ident.Name] = true
+	}
+	return names
+}
+
+// apiHandlers collects the code of the API struct recvName. Its ServeHTTP
+// matches static urls by a switch and tries urls with placeholders in
+// declaration order after them; a url that is not found is answered with 404.
+func apiHandlers(pkg *types.Package, recvName string, funcData []FuncData, qualifier types.Qualifier) HandlersApi {
+	api := HandlersApi{Name: recvName}
+	for _, route := range groupByUrl(funcData) {
+		if route.IsPattern {
+			api.Patterns = append(api.Patterns, routeSwitch(route, "pathParams"))
+		} else {
+			api.Static = append(api.Static, routeSwitch(route, "nil"))
+		}
+	}
+	authenticates := authenticator(pkg, recvName) != nil
+	for _, datum := range funcData {
+		api.Methods = append(api.Methods, handlersMethod(pkg, datum, authenticates, qualifier))
+	}
+	return api
+}
+
+func handlersMethod(pkg *types.Package, funcData FuncData, authenticates bool, qualifier types.Qualifier) HandlersMethod {
+	paramsType, paramsStruct := paramsOf(funcData)
+	method := HandlersMethod{
+		Api:         funcData.RecvName,
+		Name:        funcData.MethodName,
+		AuthHeader:  *authHeader,
+		AuthStatus:  authStatuses[*authStatus],
+		Roles:       quoteAll(funcData.Api.Roles),
+		Timeout:     funcData.Timeout,
+		ParamsArg:   "converted",
+		FieldErrors: *fieldErrors,
+	}
+	if funcData.Api.Auth && authenticates {
+		method.Auth = "authenticate"
+	} else if funcData.Api.Auth {
+		method.Auth = "header"
+	}
+	if funcData.Api.MinStatus != nil {
+		method.MinStatus, method.HasMinStatus = *funcData.Api.MinStatus, true
+	}
+	if result := resultOf(funcData); result != nil {
+		method.ResultType = types.TypeString(result, qualifier)
+	}
+	if paramsType != nil {
+		method.ParamsType = types.TypeString(paramsType, qualifier)
+		if _, isPointer := funcData.Signature.Params().At(1).Type().(*types.Pointer); isPointer {
+			method.ParamsArg = "&converted"
+		}
+	}
+
+	fields := paramFields(paramsStruct, pkg)
+	fail := returnFailure(method.ParamsType)
+	if *fieldErrors {
+		fail = collectFailure
+	}
+	for _, field := range fields {
+		method.Fields = append(method.Fields, fieldConversion(field, fail, qualifier))
+	}
+	for _, field := range fields {
+		method.Assignments = append(method.Assignments, fieldAssignment(field, qualifier))
+	}
+	return method
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONKind is the shape encoding/json marshals a type to.
+type JSONKind int
+
+const (
+	JSONUnknown JSONKind = iota
+	JSONBasic
+	// JSONTime is time.Time, marshaled as an RFC 3339 string
+	JSONTime
+	// JSONBytes is a []byte, marshaled as a base64 string
+	JSONBytes
+	JSONPointer
+	JSONSlice
+	JSONArray
+	JSONMap
+	JSONStruct
+	// JSONRef is a named struct, declared in jsonTypes.structs
+	JSONRef
+)
+
+// JSONType describes a result type the way encoding/json marshals it, the
+// TypeScript and OpenAPI emitters render it.
+type JSONType struct {
+	Kind  JSONKind
+	Basic *types.Basic
+	// Elem is the type pointed to, the item or the map value
+	Elem   *JSONType
+	Name   string
+	Fields []JSONField
+}
+
+// JSONField is a struct field under its json name.
+type JSONField struct {
+	Name      string
+	OmitEmpty bool
+	Type      *JSONType
+}
+
+// jsonTypes converts the result types and collects the named structs they
+// refer to.
+type jsonTypes struct {
+	structs map[string]*JSONType
+}
+
+func newJSONTypes() *jsonTypes {
+	return &jsonTypes{structs: make(map[string]*JSONType)}
+}
+
+func (j *jsonTypes) convert(t types.Type) *JSONType {
+	switch t := t.(type) {
+	case *types.Pointer:
+		return &JSONType{Kind: JSONPointer, Elem: j.convert(t.Elem())}
+	case *types.Named:
+		if obj := t.Obj(); obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Time" {
+			return &JSONType{Kind: JSONTime}
+		}
+		if structType, ok := t.Underlying().(*types.Struct); ok {
+			name := t.Obj().Name()
+			if _, ok := j.structs[name]; !ok {
+				// registered before the fields to stop on recursive types
+				j.structs[name] = nil
+				j.structs[name] = j.convert(structType)
+			}
+			return &JSONType{Kind: JSONRef, Name: name}
+		}
+		return j.convert(t.Underlying())
+	case *types.Basic:
+		return &JSONType{Kind: JSONBasic, Basic: t}
+	case *types.Slice:
+		if basic, ok := t.Elem().Underlying().(*types.Basic); ok && basic.Kind() == types.Byte {
+			return &JSONType{Kind: JSONBytes}
+		}
+		return &JSONType{Kind: JSONSlice, Elem: j.convert(t.Elem())}
+	case *types.Array:
+		return &JSONType{Kind: JSONArray, Elem: j.convert(t.Elem())}
+	case *types.Map:
+		return &JSONType{Kind: JSONMap, Elem: j.convert(t.Elem())}
+	case *types.Struct:
+		structType := &JSONType{Kind: JSONStruct}
+		for i := 0; i < t.NumFields(); i++ {
+			field := t.Field(i)
+			if !field.Exported() {
+				continue
+			}
+			name := field.Name()
+			jsonTag := strings.Split(reflect.StructTag(t.Tag(i)).Get("json"), ",")
+			if jsonTag[0] == "-" {
+				continue
+			}
+			if jsonTag[0] != "" {
+				name = jsonTag[0]
+			}
+			structType.Fields = append(structType.Fields, JSONField{
+				Name:      name,
+				OmitEmpty: containsString(jsonTag[1:], "omitempty"),
+				Type:      j.convert(field.Type()),
+			})
+		}
+		return structType
+	}
+	return &JSONType{Kind: JSONUnknown}
+}
+
+func (j *jsonTypes) sortedNames() []string {
+	names := make([]string, 0, len(j.structs))
+	for name := range j.structs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema is a node of the OpenAPI document. Maps marshal with sorted keys,
+// so the same package always produces the same document.
+type Schema map[string]interface{}
+
+// OpenAPI collects the document of a single API struct.
+type OpenAPI struct {
+	pkg     *types.Package
+	json    *jsonTypes
+	schemas Schema
+}
+
+func newOpenAPI(pkg *types.Package) *OpenAPI {
+	return &OpenAPI{pkg: pkg, json: newJSONTypes(), schemas: Schema{}}
+}
+
+// renderOpenAPI renders an OpenAPI 3 document for every API struct of the
+// package. With a single API struct the document is written to output,
+// otherwise the name of the struct is inserted before the extension of output,
+// e.g. openapi.MyApi.json. The document is JSON, which is valid YAML as well.
+func renderOpenAPI(data PackageData, mapData map[string][]FuncData, output string) ([]Output, error) {
+	outputs := make([]Output, 0, len(mapData))
+	for _, recvName := range sortedKeys(mapData) {
+		path := output
+		if len(mapData) > 1 {
+			ext := filepath.Ext(output)
+			path = strings.TrimSuffix(output, ext) + "." + recvName + ext
+		}
+
+		spec := newOpenAPI(data.Package).document(recvName, mapData[recvName])
+		content, err := json.MarshalIndent(spec, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, Output{Path: path, Content: append(content, '\n'), Kind: "OpenAPI"})
+	}
+	return outputs, nil
+}
+
+func (o *OpenAPI) document(recvName string, funcData []FuncData) Schema {
+	o.schemas["Error"] = Schema{
+		"type":       "object",
+		"required":   []string{"error"},
+		"properties": Schema{"error": Schema{"type": "string"}},
+	}
+
+	paths := Schema{}
+	httpMethods := operationMethods(funcData)
+	for _, route := range groupByUrl(funcData) {
+		// the operations follow the router, see routeOperations
+		byMethod := routeOperations(route)
+		methods := make([]string, 0, len(byMethod))
+		for method := range byMethod {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		item := Schema{}
+		for _, method := range methods {
+			datum := byMethod[method]
+			operationID := datum.MethodName
+			if len(httpMethods[datum.MethodName]) > 1 {
+				operationID += method[:1] + strings.ToLower(method[1:])
+			}
+			item[strings.ToLower(method)] = o.operation(datum, method, operationID)
+		}
+		paths[route.Url] = item
+	}
+	for _, name := range o.json.sortedNames() {
+		o.schemas[name] = o.jsonSchema(o.json.structs[name])
+	}
+
+	return Schema{
+		"openapi": "3.0.3",
+		"info": Schema{
+			"title":   recvName,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": Schema{
+			"schemas": o.schemas,
+			"securitySchemes": Schema{
+				"apiKey": Schema{
+					"type": "apiKey",
+					"in":   "header",
+					"name": *authHeader,
+				},
+			},
+		},
+	}
+}
+
+func (o *OpenAPI) operation(funcData FuncData, method string, operationID string) Schema {
+	paramsType, paramsStruct := paramsOf(funcData)
+	inBody := paramsType != nil && paramsInBody(method)
+
+	parameters := make([]Schema, 0)
+	bodyProperties := Schema{}
+	bodyRequired := make([]string, 0)
+	for _, field := range paramFields(paramsStruct, o.pkg) {
+		args := parseValidatorArgs(field.Tag)
+		name := paramName(field, args)
+		// fields of a pointer struct are only required once the struct is sent
+		required := args.Required && field.requiredIf() == ""
+		schema := o.paramSchema(field, args)
+		switch {
+		case args.Source == "path":
+			parameters = append(parameters, Schema{"name": name, "in": "path", "required": true, "schema": schema})
+		case inBody:
+			bodyProperties[name] = schema
+			if required {
+				bodyRequired = append(bodyRequired, name)
+			}
+		default:
+			parameter := Schema{"name": name, "in": "query", "required": required, "schema": schema}
+			if _, isSlice := field.Type().Underlying().(*types.Slice); isSlice {
+				parameter["style"] = "form"
+				parameter["explode"] = !args.Csv
+			}
+			parameters = append(parameters, parameter)
+		}
+	}
+
+	operation := Schema{
+		"operationId": operationID,
+		"responses":   o.responses(funcData),
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if inBody {
+		body := Schema{"type": "object", "properties": bodyProperties}
+		if len(bodyRequired) > 0 {
+			body["required"] = bodyRequired
+		}
+		operation["requestBody"] = Schema{
+			"content": Schema{
+				"application/x-www-form-urlencoded": Schema{"schema": body},
+				"application/json":                  Schema{"schema": body},
+				"multipart/form-data":               Schema{"schema": body},
+			},
+		}
+	}
+	if funcData.Api.Auth {
+		operation["security"] = []Schema{{"apiKey": []string{}}}
+	}
+	return operation
+}
+
+func (o *OpenAPI) responses(funcData FuncData) Schema {
+	// a method returning only an error responds with "response": null
+	response := Schema{"nullable": true}
+	if result := resultOf(funcData); result != nil {
+		response = o.typeSchema(result)
+	}
+	responses := Schema{
+		"200": Schema{
+			"description": "OK",
+			"content": Schema{
+				"application/json": Schema{
+					"schema": Schema{
+						"type":     "object",
+						"required": []string{"error", "response"},
+						"properties": Schema{
+							"error":    Schema{"type": "string"},
+							"response": response,
+						},
+					},
+				},
+			},
+		},
+		"400":     o.errorResponse("invalid params"),
+		"default": o.errorResponse("error returned by the method"),
+	}
+	if *fieldErrors {
+		responses["400"] = o.validationErrorResponse()
+	}
+	if len(funcData.Methods) > 0 {
+		responses["405"] = o.errorResponse("method not allowed")
+	}
+	if funcData.Api.Auth {
+		responses[fmt.Sprint(*authStatus)] = o.errorResponse("unauthorized")
+	}
+	if len(funcData.Api.Roles) > 0 || funcData.Api.MinStatus != nil {
+		responses["403"] = o.errorResponse("forbidden")
+	}
+	if funcData.Timeout > 0 {
+		responses["504"] = o.errorResponse("timeout")
+	}
+	return responses
+}
+
+func (o *OpenAPI) errorResponse(description string) Schema {
+	return Schema{
+		"description": description,
+		"content": Schema{
+			"application/json": Schema{"schema": Schema{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+}
+
+// validationErrorResponse describes the 400 response listing every invalid
+// param, see -fielderrors.
+func (o *OpenAPI) validationErrorResponse() Schema {
+	o.schemas["ValidationError"] = Schema{
+		"type":     "object",
+		"required": []string{"error", "fields"},
+		"properties": Schema{
+			"error": Schema{"type": "string"},
+			"fields": Schema{
+				"type": "array",
+				"items": Schema{
+					"type":     "object",
+					"required": []string{"param", "rule", "message"},
+					"properties": Schema{
+						"param":   Schema{"type": "string"},
+						"rule":    Schema{"type": "string"},
+						"message": Schema{"type": "string"},
+					},
+				},
+			},
+		},
+	}
+	return Schema{
+		"description": "invalid params",
+		"content": Schema{
+			"application/json": Schema{"schema": Schema{"$ref": "#/components/schemas/ValidationError"}},
+		},
+	}
+}
+
+// paramSchema describes a params field together with its apivalidator rules.
+func (o *OpenAPI) paramSchema(field ParamField, args ValidatorArgs) Schema {
+	if slice, ok := field.Type().Underlying().(*types.Slice); ok {
+		items := basicSchema(fieldBasic(slice.Elem(), field.Name()))
+		addValueConstraints(items, args)
+		schema := Schema{"type": "array", "items": items}
+		if args.HasMinItems {
+			schema["minItems"] = args.MinItems
+		}
+		if args.HasMaxItems {
+			schema["maxItems"] = args.MaxItems
+		}
+		return schema
+	}
+
+	schema := basicSchema(fieldBasic(field.Type(), field.Name()))
+	addValueConstraints(schema, args)
+	if args.HasDefault {
+		schema["default"] = defaultValue(schema, args.Default)
+	}
+	return schema
+}
+
+// addValueConstraints adds the rules checked for every single value.
+func addValueConstraints(schema Schema, args ValidatorArgs) {
+	minKey, maxKey := "minimum", "maximum"
+	if schema["type"] == "string" {
+		minKey, maxKey = "minLength", "maxLength"
+	}
+	if args.HasMin {
+		schema[minKey] = args.Min
+	}
+	if args.HasMax {
+		schema[maxKey] = args.Max
+	}
+	if args.HasEnum {
+		schema["enum"] = args.Enum.Values
+	}
+	if args.HasLen {
+		schema["minLength"], schema["maxLength"] = args.Len, args.Len
+	}
+	if args.Pattern != "" {
+		schema["pattern"] = args.Pattern
+	}
+	switch {
+	case args.Email:
+		schema["format"] = "email"
+	case args.UUID:
+		schema["format"] = "uuid"
+	case args.URL:
+		schema["format"] = "uri"
+	}
+	if len(args.OneOf) > 0 {
+		oneOf := make([]int64, 0, len(args.OneOf))
+		for _, value := range args.OneOf {
+			number, _ := strconv.ParseInt(value, 10, 64)
+			oneOf = append(oneOf, number)
+		}
+		schema["enum"] = oneOf
+	}
+}
+
+// defaultValue converts a default to the type of the schema.
+func defaultValue(schema Schema, value string) interface{} {
+	switch schema["type"] {
+	case "boolean":
+		boolean, _ := strconv.ParseBool(value)
+		return boolean
+	case "integer", "number":
+		number, _ := strconv.ParseFloat(value, 64)
+		return number
+	}
+	return value
+}
+
+func basicSchema(basic *types.Basic) Schema {
+	switch {
+	case basic.Info()&types.IsBoolean != 0:
+		return Schema{"type": "boolean"}
+	case basic.Info()&types.IsInteger != 0:
+		schema := Schema{"type": "integer"}
+		switch basic.Kind() {
+		case types.Int64, types.Uint64, types.Int, types.Uint:
+			schema["format"] = "int64"
+		default:
+			schema["format"] = "int32"
+		}
+		if basic.Info()&types.IsUnsigned != 0 {
+			schema["minimum"] = 0
+		}
+		return schema
+	case basic.Info()&types.IsFloat != 0:
+		if basic.Kind() == types.Float32 {
+			return Schema{"type": "number", "format": "float"}
+		}
+		return Schema{"type": "number", "format": "double"}
+	case basic.Info()&types.IsString != 0:
+		return Schema{"type": "string"}
+	}
+	return Schema{}
+}
+
+// typeSchema describes a result type the way encoding/json marshals it.
+// Named structs are put into components by document and referenced.
+func (o *OpenAPI) typeSchema(t types.Type) Schema {
+	return o.jsonSchema(o.json.convert(t))
+}
+
+func (o *OpenAPI) jsonSchema(t *JSONType) Schema {
+	switch t.Kind {
+	case JSONPointer:
+		schema := o.jsonSchema(t.Elem)
+		schema["nullable"] = true
+		return schema
+	case JSONTime:
+		return Schema{"type": "string", "format": "date-time"}
+	case JSONBytes:
+		return Schema{"type": "string", "format": "byte"}
+	case JSONRef:
+		return Schema{"allOf": []Schema{{"$ref": "#/components/schemas/" + t.Name}}}
+	case JSONBasic:
+		return basicSchema(t.Basic)
+	case JSONSlice, JSONArray:
+		return Schema{"type": "array", "items": o.jsonSchema(t.Elem)}
+	case JSONMap:
+		return Schema{"type": "object", "additionalProperties": o.jsonSchema(t.Elem)}
+	case JSONStruct:
+		properties := Schema{}
+		for _, field := range t.Fields {
+			properties[field.Name] = o.jsonSchema(field.Type)
+		}
+		return Schema{"type": "object", "properties": properties}
+	}
+	return Schema{}
+}
@@ -0,0 +1,40 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AccessApi resolves the roles of a caller from the X-Roles header and the
+// status from X-Status, "fail" makes the resolvers fail with their own status.
+type AccessApi struct{}
+
+func (a *AccessApi) ResolveRoles(ctx context.Context, r *http.Request) ([]string, error) {
+	header := r.Header.Get("X-Roles")
+	if header == "fail" {
+		return nil, ApiError{HTTPStatus: http.StatusServiceUnavailable, Err: errors.New("roles unavailable")}
+	}
+	return strings.Split(header, ","), nil
+}
+
+func (a *AccessApi) ResolveStatus(ctx context.Context, r *http.Request) (int, error) {
+	header := r.Header.Get("X-Status")
+	if header == "fail" {
+		return 0, &ApiError{HTTPStatus: http.StatusBadGateway, Err: errors.New("status unavailable")}
+	}
+	status, _ := strconv.Atoi(header)
+	return status, nil
+}
+
+// apigen:api {"url": "/admin", "method": "GET", "roles": ["admin", "moderator"]}
+func (a *AccessApi) Admin(ctx context.Context) (*Served, error) {
+	return &Served{Method: "Admin"}, nil
+}
+
+// apigen:api {"url": "/vip", "method": "GET", "minStatus": 10}
+func (a *AccessApi) Vip(ctx context.Context) (*Served, error) {
+	return &Served{Method: "Vip"}, nil
+}
@@ -0,0 +1,81 @@
+//go:build !fielderrors
+
+package features
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	runCases(t, &AccessApi{}, []Case{
+		{
+			Name:          "one of the roles",
+			Method:        http.MethodGet,
+			Path:          "/admin",
+			RequestHeader: map[string]string{"X-Roles": "user,moderator"},
+			Status:        http.StatusOK,
+			Result:        CR{"error": "", "response": CR{"method": "Admin"}},
+		},
+		{
+			Name:          "none of the roles",
+			Method:        http.MethodGet,
+			Path:          "/admin",
+			RequestHeader: map[string]string{"X-Roles": "user"},
+			Status:        http.StatusForbidden,
+			Result:        CR{"error": "forbidden"},
+		},
+		{
+			Name:   "no roles",
+			Method: http.MethodGet,
+			Path:   "/admin",
+			Status: http.StatusForbidden,
+			Result: CR{"error": "forbidden"},
+		},
+		{
+			Name:          "resolver error",
+			Method:        http.MethodGet,
+			Path:          "/admin",
+			RequestHeader: map[string]string{"X-Roles": "fail"},
+			Status:        http.StatusServiceUnavailable,
+			Result:        CR{"error": "roles unavailable"},
+		},
+	})
+}
+
+func TestMinStatus(t *testing.T) {
+	runCases(t, &AccessApi{}, []Case{
+		{
+			Name:          "status above",
+			Method:        http.MethodGet,
+			Path:          "/vip",
+			RequestHeader: map[string]string{"X-Status": "20"},
+			Status:        http.StatusOK,
+			Result:        CR{"error": "", "response": CR{"method": "Vip"}},
+		},
+		{
+			Name:          "status equal",
+			Method:        http.MethodGet,
+			Path:          "/vip",
+			RequestHeader: map[string]string{"X-Status": "10"},
+			Status:        http.StatusOK,
+			Result:        CR{"error": "", "response": CR{"method": "Vip"}},
+		},
+		{
+			Name:          "status below",
+			Method:        http.MethodGet,
+			Path:          "/vip",
+			RequestHeader: map[string]string{"X-Status": "9"},
+			Status:        http.StatusForbidden,
+			Result:        CR{"error": "forbidden"},
+		},
+		{
+			Name:          "resolver error",
+			Method:        http.MethodGet,
+			Path:          "/vip",
+			RequestHeader: map[string]string{"X-Status": "fail"},
+			Status:        http.StatusBadGateway,
+			Result:        CR{"error": "status unavailable"},
+		},
+	})
+}
@@ -0,0 +1,179 @@
+// Package features exercises the generated handlers beyond api.go of the
+// repository. TestFeatures copies it into a module of its own, generates its
+// handlers and its Go client into client, and runs the tests against them.
+package features
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+type CreateParams struct {
+	Name   string   `apivalidator:"required"`
+	Age    int      `apivalidator:"min=0"`
+	Active bool     `json:"active"`
+	Tags   []string `json:"tags"`
+}
+
+type Created struct {
+	Name   string   `json:"name"`
+	Age    int      `json:"age"`
+	Active bool     `json:"active"`
+	Tags   []string `json:"tags"`
+}
+
+// apigen:api {"url": "/create", "method": "POST"}
+func (a *Api) Create(ctx context.Context, in CreateParams) (*Created, error) {
+	return &Created{Name: in.Name, Age: in.Age, Active: in.Active, Tags: in.Tags}, nil
+}
+
+type PostParams struct {
+	Login string `apivalidator:"source=path,required"`
+	ID    int    `apivalidator:"source=path,min=1"`
+}
+
+type Post struct {
+	Login string `json:"login"`
+	ID    int    `json:"id"`
+}
+
+// apigen:api {"url": "/user/{login}/posts/{id}", "method": "GET"}
+func (a *Api) Post(ctx context.Context, in PostParams) (*Post, error) {
+	return &Post{Login: in.Login, ID: in.ID}, nil
+}
+
+// Served tells which method served a request routed by url and HTTP method.
+type Served struct {
+	Method string `json:"method"`
+}
+
+// apigen:api {"url": "/item", "method": "GET"}
+func (a *Api) GetItem(ctx context.Context) (*Served, error) {
+	return &Served{Method: "GetItem"}, nil
+}
+
+// apigen:api {"url": "/item", "methods": ["PUT", "PATCH"]}
+func (a *Api) UpdateItem(ctx context.Context) (*Served, error) {
+	return &Served{Method: "UpdateItem"}, nil
+}
+
+// apigen:api {"url": "/mixed", "method": "GET"}
+func (a *Api) GetMixed(ctx context.Context) (*Served, error) {
+	return &Served{Method: "GetMixed"}, nil
+}
+
+// apigen:api {"url": "/mixed"}
+func (a *Api) AnyMixed(ctx context.Context) (*Served, error) {
+	return &Served{Method: "AnyMixed"}, nil
+}
+
+type LevelParams struct {
+	Level int `apivalidator:"min=1,max=50"`
+	Rank  int `apivalidator:"default=3"`
+}
+
+type Level struct {
+	Level int `json:"level"`
+	Rank  int `json:"rank"`
+}
+
+// apigen:api {"url": "/level", "method": "POST"}
+func (a *Api) Level(ctx context.Context, in LevelParams) (*Level, error) {
+	return &Level{Level: in.Level, Rank: in.Rank}, nil
+}
+
+type FailParams struct {
+	Kind string `apivalidator:"enum=value|pointer|wrapped|plain"`
+}
+
+// apigen:api {"url": "/fail", "method": "GET"}
+func (a *Api) Fail(ctx context.Context, in FailParams) (*Served, error) {
+	switch in.Kind {
+	case "value":
+		return nil, ApiError{HTTPStatus: http.StatusNotFound, Err: errors.New("value not found")}
+	case "pointer":
+		return nil, &ApiError{HTTPStatus: http.StatusConflict, Err: errors.New("pointer conflict")}
+	case "wrapped":
+		return nil, fmt.Errorf("wrapped: %w", &ApiError{HTTPStatus: http.StatusGone, Err: errors.New("gone")})
+	}
+	return nil, errors.New("plain")
+}
+
+type SlowParams struct {
+	Sleep int `apivalidator:"min=0"`
+	Panic bool
+}
+
+// Slow sleeps without watching the context, so only the timeout of the
+// handler ends the request.
+//
+// apigen:api {"url": "/slow", "method": "GET", "timeout": "50ms"}
+func (a *Api) Slow(ctx context.Context, in SlowParams) (*Served, error) {
+	if in.Panic {
+		panic("slow panic")
+	}
+	time.Sleep(time.Duration(in.Sleep) * time.Millisecond)
+	return &Served{Method: "Slow"}, nil
+}
+
+type Address struct {
+	City string `apivalidator:"required"`
+	Zip  int    `apivalidator:"min=1"`
+}
+
+type Office struct {
+	Floor int
+}
+
+type Paging struct {
+	Limit int `apivalidator:"default=10"`
+}
+
+type ProfileParams struct {
+	Paging
+	Name    *string
+	Age     *int
+	Address *Address
+	Office  Office
+	// the handlers declare variables for it apart from Address.City
+	AddressCity string
+}
+
+// Profile shows the params a method received, nil pointers as "nil".
+type Profile struct {
+	Limit   int    `json:"limit"`
+	Name    string `json:"name"`
+	Age     string `json:"age"`
+	Address string `json:"address"`
+	Floor   int    `json:"floor"`
+}
+
+// apigen:api {"url": "/profile", "methods": ["GET", "POST"]}
+func (a *Api) Profile(ctx context.Context, in ProfileParams) (*Profile, error) {
+	profile := &Profile{Limit: in.Limit, Name: "nil", Age: "nil", Address: "nil", Floor: in.Office.Floor}
+	if in.Name != nil {
+		profile.Name = strconv.Quote(*in.Name)
+	}
+	if in.Age != nil {
+		profile.Age = strconv.Itoa(*in.Age)
+	}
+	if in.Address != nil {
+		profile.Address = in.Address.City + " " + strconv.Itoa(in.Address.Zip)
+	}
+	return profile, nil
+}
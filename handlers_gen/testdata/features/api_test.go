@@ -0,0 +1,291 @@
+//go:build !fielderrors
+
+package features
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+func TestBody(t *testing.T) {
+	form := new(bytes.Buffer)
+	writer := multipart.NewWriter(form)
+	writer.WriteField("name", "ivan")
+	writer.WriteField("age", "7")
+	writer.WriteField("tags", "a")
+	writer.WriteField("tags", "b")
+	writer.Close()
+
+	runCases(t, &Api{}, []Case{
+		{
+			Name:        "json body",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{"name": "ivan", "age": 7, "active": true, "tags": ["a", "b"]}`,
+			Status:      http.StatusOK,
+			Result:      CR{"error": "", "response": CR{"name": "ivan", "age": 7, "active": true, "tags": []string{"a", "b"}}},
+		},
+		{
+			Name:        "json with charset",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json; charset=utf-8",
+			Body:        `{"name": "ivan"}`,
+			Status:      http.StatusOK,
+			Result:      CR{"error": "", "response": CR{"name": "ivan", "age": 0, "active": false, "tags": []string{}}},
+		},
+		{
+			Name:        "json number out of range",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{"name": "ivan", "age": 1.5}`,
+			Status:      http.StatusBadRequest,
+			Result:      CR{"error": "age must be int"},
+		},
+		{
+			Name:        "empty json body",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Status:      http.StatusBadRequest,
+			Result:      CR{"error": "name must me not empty"},
+		},
+		{
+			Name:        "malformed json",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{"name": `,
+			Status:      http.StatusBadRequest,
+			Result:      CR{"error": "bad params: unexpected EOF"},
+		},
+		{
+			Name:        "multipart form",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: writer.FormDataContentType(),
+			Body:        form.String(),
+			Status:      http.StatusOK,
+			Result:      CR{"error": "", "response": CR{"name": "ivan", "age": 7, "active": false, "tags": []string{"a", "b"}}},
+		},
+		{
+			Name:        "url encoded form",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        "name=ivan%20ivanov&active=1&tags=a&tags=b",
+			Status:      http.StatusOK,
+			Result:      CR{"error": "", "response": CR{"name": "ivan ivanov", "age": 0, "active": true, "tags": []string{"a", "b"}}},
+		},
+		{
+			Name:   "post ignores the query",
+			Method: http.MethodPost,
+			Path:   "/create",
+			Query:  "name=ivan",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "name must me not empty"},
+		},
+	})
+}
+
+func TestPathParams(t *testing.T) {
+	runCases(t, &Api{}, []Case{
+		{
+			Name:   "placeholders",
+			Path:   "/user/ivan/posts/3",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "ivan", "id": 3}},
+		},
+		{
+			Name:   "escaped slash stays in its segment",
+			Path:   "/user/iv%2Fan%20i/posts/3",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "iv/an i", "id": 3}},
+		},
+		{
+			Name:   "path value is validated",
+			Path:   "/user/ivan/posts/0",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "id must be >= 1"},
+		},
+		{
+			Name:   "path value is parsed",
+			Path:   "/user/ivan/posts/x",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "id must be int"},
+		},
+		{
+			Name:   "query does not override the path",
+			Path:   "/user/ivan/posts/3",
+			Query:  "login=petr&id=4",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"login": "ivan", "id": 3}},
+		},
+		{
+			Name:   "missing segment",
+			Path:   "/user/ivan/posts",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown method"},
+		},
+		{
+			Name:   "extra segment",
+			Path:   "/user/ivan/posts/3/comments",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "unknown method"},
+		},
+	})
+}
+
+func TestRouting(t *testing.T) {
+	served := func(method string) CR {
+		return CR{"error": "", "response": CR{"method": method}}
+	}
+	runCases(t, &Api{}, []Case{
+		{Name: "get", Method: http.MethodGet, Path: "/item", Status: http.StatusOK, Result: served("GetItem")},
+		{Name: "put", Method: http.MethodPut, Path: "/item", Status: http.StatusOK, Result: served("UpdateItem")},
+		{Name: "patch", Method: http.MethodPatch, Path: "/item", Status: http.StatusOK, Result: served("UpdateItem")},
+		{Name: "head is served by get", Method: http.MethodHead, Path: "/item", Status: http.StatusOK},
+		{
+			Name:   "options",
+			Method: http.MethodOptions,
+			Path:   "/item",
+			Status: http.StatusNoContent,
+			Header: map[string]string{"Allow": "GET, HEAD, OPTIONS, PATCH, PUT"},
+		},
+		{
+			Name:   "method not allowed",
+			Method: http.MethodDelete,
+			Path:   "/item",
+			Status: http.StatusMethodNotAllowed,
+			Header: map[string]string{"Allow": "GET, HEAD, OPTIONS, PATCH, PUT"},
+			Result: CR{"error": "bad method"},
+		},
+		{Name: "sibling claims get", Method: http.MethodGet, Path: "/mixed", Status: http.StatusOK, Result: served("GetMixed")},
+		{Name: "catch-all serves post", Method: http.MethodPost, Path: "/mixed", Status: http.StatusOK, Result: served("AnyMixed")},
+		{Name: "catch-all serves delete", Method: http.MethodDelete, Path: "/mixed", Status: http.StatusOK, Result: served("AnyMixed")},
+		{Name: "catch-all serves options", Method: http.MethodOptions, Path: "/mixed", Status: http.StatusOK, Result: served("AnyMixed")},
+		{Name: "unknown url", Method: http.MethodGet, Path: "/items", Status: http.StatusNotFound, Result: CR{"error": "unknown method"}},
+	})
+}
+
+func TestNestedParams(t *testing.T) {
+	profile := func(limit int, name string, age string, address string, floor int) CR {
+		return CR{"error": "", "response": CR{"limit": limit, "name": name, "age": age, "address": address, "floor": floor}}
+	}
+	runCases(t, &Api{}, []Case{
+		{Name: "missing params", Path: "/profile", Status: http.StatusOK, Result: profile(10, "nil", "nil", "nil", 0)},
+		{Name: "empty and zero params are present", Path: "/profile", Query: "name=&age=0", Status: http.StatusOK, Result: profile(10, `""`, "0", "nil", 0)},
+		{Name: "embedded struct is promoted", Path: "/profile", Query: "limit=3", Status: http.StatusOK, Result: profile(3, "nil", "nil", "nil", 0)},
+		{Name: "nested struct", Path: "/profile", Query: "office.floor=2", Status: http.StatusOK, Result: profile(10, "nil", "nil", "nil", 2)},
+		{Name: "pointer struct is allocated", Path: "/profile", Query: "address.city=Kazan", Status: http.StatusOK, Result: profile(10, "nil", "nil", "Kazan 0", 0)},
+		{
+			Name:   "required applies once the pointer struct is sent",
+			Path:   "/profile",
+			Query:  "address.zip=420",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "address.city must me not empty"},
+		},
+		{
+			Name:   "nested fields are validated",
+			Path:   "/profile",
+			Query:  "address.city=Kazan&address.zip=0",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "address.zip must be >= 1"},
+		},
+		{
+			Name:        "nested json objects",
+			Method:      http.MethodPost,
+			Path:        "/profile",
+			ContentType: "application/json",
+			Body:        `{"name": "", "address": {"city": "Kazan", "zip": 420}, "office": {"floor": 3}}`,
+			Status:      http.StatusOK,
+			Result:      profile(10, `""`, "nil", "Kazan 420", 3),
+		},
+	})
+}
+
+func TestErrorStatus(t *testing.T) {
+	runCases(t, &Api{}, []Case{
+		{
+			Name:   "ApiError value",
+			Method: http.MethodGet,
+			Path:   "/fail",
+			Query:  "kind=value",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "value not found"},
+		},
+		{
+			Name:   "ApiError pointer",
+			Method: http.MethodGet,
+			Path:   "/fail",
+			Query:  "kind=pointer",
+			Status: http.StatusConflict,
+			Result: CR{"error": "pointer conflict"},
+		},
+		{
+			Name:   "wrapped ApiError pointer",
+			Method: http.MethodGet,
+			Path:   "/fail",
+			Query:  "kind=wrapped",
+			Status: http.StatusGone,
+			Result: CR{"error": "wrapped: gone"},
+		},
+		{
+			Name:   "other error",
+			Method: http.MethodGet,
+			Path:   "/fail",
+			Query:  "kind=plain",
+			Status: http.StatusInternalServerError,
+			Result: CR{"error": "plain"},
+		},
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	runCases(t, &Api{}, []Case{
+		{
+			Name:   "under the deadline",
+			Method: http.MethodGet,
+			Path:   "/slow",
+			Query:  "sleep=1",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"method": "Slow"}},
+		},
+		{
+			Name:   "over the deadline",
+			Method: http.MethodGet,
+			Path:   "/slow",
+			Query:  "sleep=300",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{"error": "timeout"},
+		},
+	})
+
+	// the panic of the method goroutine reaches the handler goroutine, where
+	// net/http or a middleware like this one recovers it
+	var recovered interface{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if recovered = recover(); recovered != nil {
+				http.Error(w, "recovered", http.StatusInternalServerError)
+			}
+		}()
+		(&Api{}).ServeHTTP(w, r)
+	})
+	runCases(t, handler, []Case{
+		{
+			Name:   "panic",
+			Method: http.MethodGet,
+			Path:   "/slow",
+			Query:  "panic=true",
+			Status: http.StatusInternalServerError,
+		},
+	})
+	if recovered != "slow panic" {
+		t.Errorf("recovered %v, want the panic of the method", recovered)
+	}
+}
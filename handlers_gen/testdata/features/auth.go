@@ -0,0 +1,48 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// User is the principal of AuthApi.
+type User struct {
+	Name string `json:"name"`
+}
+
+// AuthApi authenticates the bearer token of the Authorization header, the
+// token being the user name. The user "banned" is refused with its own status.
+type AuthApi struct{}
+
+func (a *AuthApi) Authenticate(ctx context.Context, r *http.Request) (User, error) {
+	name := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	switch name {
+	case "":
+		return User{}, errors.New("no token")
+	case "banned":
+		return User{}, ApiError{HTTPStatus: http.StatusForbidden, Err: errors.New("banned")}
+	}
+	return User{Name: name}, nil
+}
+
+// apigen:api {"url": "/whoami", "method": "GET", "auth": true}
+func (a *AuthApi) Whoami(ctx context.Context) (*User, error) {
+	user := principalFromContext(ctx).(User)
+	return &user, nil
+}
+
+// apigen:api {"url": "/public", "method": "GET"}
+func (a *AuthApi) Public(ctx context.Context) (*Served, error) {
+	return &Served{Method: "Public"}, nil
+}
+
+// TokenApi has no Authenticate, so the handlers only check that the header of
+// -authheader is not empty.
+type TokenApi struct{}
+
+// apigen:api {"url": "/token", "method": "GET", "auth": true}
+func (a *TokenApi) Token(ctx context.Context) (*Served, error) {
+	return &Served{Method: "Token"}, nil
+}
@@ -0,0 +1,73 @@
+//go:build !fielderrors
+
+package features
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestFeatures generates the handlers with -authheader X-Token and
+// -authstatus 401.
+func TestAuthenticate(t *testing.T) {
+	runCases(t, &AuthApi{}, []Case{
+		{
+			Name:          "principal",
+			Method:        http.MethodGet,
+			Path:          "/whoami",
+			RequestHeader: map[string]string{"Authorization": "Bearer ivan"},
+			Status:        http.StatusOK,
+			Result:        CR{"error": "", "response": CR{"name": "ivan"}},
+		},
+		{
+			Name:   "refused with -authstatus",
+			Method: http.MethodGet,
+			Path:   "/whoami",
+			Status: http.StatusUnauthorized,
+			Result: CR{"error": "no token"},
+		},
+		{
+			Name:          "refused with the status of the error",
+			Method:        http.MethodGet,
+			Path:          "/whoami",
+			RequestHeader: map[string]string{"Authorization": "Bearer banned"},
+			Status:        http.StatusForbidden,
+			Result:        CR{"error": "banned"},
+		},
+		{
+			Name:   "no auth",
+			Method: http.MethodGet,
+			Path:   "/public",
+			Status: http.StatusOK,
+			Result: CR{"error": "", "response": CR{"method": "Public"}},
+		},
+	})
+}
+
+func TestAuthHeader(t *testing.T) {
+	runCases(t, &TokenApi{}, []Case{
+		{
+			Name:          "token",
+			Method:        http.MethodGet,
+			Path:          "/token",
+			RequestHeader: map[string]string{"X-Token": "100500"},
+			Status:        http.StatusOK,
+			Result:        CR{"error": "", "response": CR{"method": "Token"}},
+		},
+		{
+			Name:   "no token",
+			Method: http.MethodGet,
+			Path:   "/token",
+			Status: http.StatusUnauthorized,
+			Result: CR{"error": "unauthorized"},
+		},
+		{
+			Name:          "default header",
+			Method:        http.MethodGet,
+			Path:          "/token",
+			RequestHeader: map[string]string{"X-Auth": "100500"},
+			Status:        http.StatusUnauthorized,
+			Result:        CR{"error": "unauthorized"},
+		},
+	})
+}
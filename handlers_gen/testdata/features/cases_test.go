@@ -0,0 +1,86 @@
+package features
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type Case struct {
+	Name        string
+	Method      string
+	Path        string
+	Query       string
+	ContentType string
+	// RequestHeader are the headers of the request
+	RequestHeader map[string]string
+	Body          string
+	Status        int
+	// Header are the response headers to check
+	Header map[string]string
+	Result interface{}
+}
+
+// CR is a JSON object of a response.
+type CR map[string]interface{}
+
+// runCases sends the request of every case to handler and compares the
+// response with the expected one.
+func runCases(t *testing.T, handler http.Handler, cases []Case) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, item := range cases {
+		url := server.URL + item.Path
+		if item.Query != "" {
+			url += "?" + item.Query
+		}
+		req, err := http.NewRequest(item.Method, url, bytes.NewBufferString(item.Body))
+		if err != nil {
+			t.Fatalf("[%s] %v", item.Name, err)
+		}
+		if item.ContentType != "" {
+			req.Header.Set("Content-Type", item.ContentType)
+		}
+		for name, value := range item.RequestHeader {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("[%s] request error: %v", item.Name, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%s] expected http status %d, got %d: %s", item.Name, item.Status, resp.StatusCode, body)
+			continue
+		}
+		for name, value := range item.Header {
+			if got := resp.Header.Get(name); got != value {
+				t.Errorf("[%s] expected %s header %q, got %q", item.Name, name, value, got)
+			}
+		}
+		if item.Result == nil {
+			continue
+		}
+
+		var result, expected interface{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf("[%s] cant unpack json %s: %v", item.Name, body, err)
+			continue
+		}
+		// round trip the expected result so that its types match the decoded ones
+		data, _ := json.Marshal(item.Result)
+		json.Unmarshal(data, &expected)
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("[%s] results not match\nGot: %s\nExpected: %s", item.Name, body, data)
+		}
+	}
+}
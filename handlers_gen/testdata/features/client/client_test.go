@@ -0,0 +1,67 @@
+//go:build !fielderrors
+
+package client_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"features"
+	"features/client"
+)
+
+func newClient(t *testing.T) *client.ApiClient {
+	t.Helper()
+	server := httptest.NewServer(&features.Api{})
+	t.Cleanup(server.Close)
+	return client.NewApiClient(server.URL, "")
+}
+
+func TestZeroValues(t *testing.T) {
+	api := newClient(t)
+	for _, test := range []struct {
+		name string
+		in   client.LevelParams
+		want client.Level
+	}{
+		// a zero level is not sent, so its min is not checked
+		{name: "zero without default", in: client.LevelParams{Rank: 3}, want: client.Level{Rank: 3}},
+		// a zero rank is sent, so it overrides the default
+		{name: "zero with default", in: client.LevelParams{Level: 5}, want: client.Level{Level: 5}},
+		{name: "values", in: client.LevelParams{Level: 5, Rank: 7}, want: client.Level{Level: 5, Rank: 7}},
+	} {
+		got, err := api.Level(context.Background(), test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+
+	_, err := api.Level(context.Background(), client.LevelParams{Level: 51})
+	if apiErr, ok := err.(client.ApiError); !ok || apiErr.HTTPStatus != 400 || apiErr.Error() != "level must be <= 50" {
+		t.Errorf("level 51: got error %v, want level must be <= 50", err)
+	}
+}
+
+func TestRoutedMethods(t *testing.T) {
+	api := newClient(t)
+	for name, call := range map[string]func(context.Context) (*client.Served, error){
+		"GetItem":    api.GetItem,
+		"UpdateItem": api.UpdateItem,
+		"GetMixed":   api.GetMixed,
+		"AnyMixed":   api.AnyMixed,
+	} {
+		served, err := call(context.Background())
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if served.Method != name {
+			t.Errorf("%s is served by %s", name, served.Method)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build fielderrors
+
+package features
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFieldErrors(t *testing.T) {
+	runCases(t, &Api{}, []Case{
+		{
+			Name:        "every invalid param is reported",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{"age": -1, "active": "maybe"}`,
+			Status:      http.StatusBadRequest,
+			Result: CR{"error": "validation failed", "fields": []CR{
+				{"param": "name", "rule": "required", "message": "name must me not empty"},
+				{"param": "age", "rule": "min", "message": "age must be >= 0"},
+				{"param": "active", "rule": "type", "message": "active must be bool"},
+			}},
+		},
+		{
+			Name:   "path params are reported alike",
+			Path:   "/user/ivan/posts/x",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "validation failed", "fields": []CR{
+				{"param": "id", "rule": "type", "message": "id must be int"},
+			}},
+		},
+		{
+			Name:        "valid params",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{"name": "ivan", "age": 7}`,
+			Status:      http.StatusOK,
+			Result:      CR{"error": "", "response": CR{"name": "ivan", "age": 7, "active": false, "tags": []string{}}},
+		},
+		{
+			Name:        "malformed body is not a validation error",
+			Method:      http.MethodPost,
+			Path:        "/create",
+			ContentType: "application/json",
+			Body:        `{`,
+			Status:      http.StatusBadRequest,
+			Result:      CR{"error": "bad params: unexpected EOF"},
+		},
+	})
+}
@@ -0,0 +1,22 @@
+package features
+
+import "context"
+
+type Size int
+
+type Color string
+
+type FormatParams struct {
+	Code  string `apivalidator:"pattern=^[A-Z]{3}$"`
+	Email string `apivalidator:"email"`
+	ID    string `apivalidator:"uuid"`
+	Site  string `apivalidator:"url"`
+	Pin   string `apivalidator:"len=4"`
+	Size  Size   `apivalidator:"oneof=1|2|4"`
+	Color Color  `apivalidator:"enum=red|green"`
+}
+
+// apigen:api {"url": "/format", "method": "GET"}
+func (a *Api) Format(ctx context.Context, in FormatParams) (*Served, error) {
+	return &Served{Method: "Format"}, nil
+}
@@ -0,0 +1,33 @@
+//go:build !fielderrors
+
+package features
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	served := CR{"error": "", "response": CR{"method": "Format"}}
+	cases := []Case{
+		{Name: "nothing sent", Query: "", Status: http.StatusOK, Result: served},
+		{
+			Name:   "all valid",
+			Query:  "code=ABC&email=ivan%40example.com&id=123e4567-e89b-12d3-a456-426614174000&site=https%3A%2F%2Fexample.com%2Fa&pin=0042&size=4&color=green",
+			Status: http.StatusOK,
+			Result: served,
+		},
+		{Name: "pattern", Query: "code=abc", Status: http.StatusBadRequest, Result: CR{"error": "code must match ^[A-Z]{3}$"}},
+		{Name: "email", Query: "email=ivan", Status: http.StatusBadRequest, Result: CR{"error": "email must be a valid email"}},
+		{Name: "email with name", Query: "email=Ivan+%3Civan%40example.com%3E", Status: http.StatusBadRequest, Result: CR{"error": "email must be a valid email"}},
+		{Name: "uuid", Query: "id=123e4567", Status: http.StatusBadRequest, Result: CR{"error": "id must be a valid uuid"}},
+		{Name: "url", Query: "site=example.com", Status: http.StatusBadRequest, Result: CR{"error": "site must be a valid url"}},
+		{Name: "len", Query: "pin=123", Status: http.StatusBadRequest, Result: CR{"error": "pin len must be 4"}},
+		{Name: "oneof", Query: "size=3", Status: http.StatusBadRequest, Result: CR{"error": "size must be one of [1, 2, 4]"}},
+		{Name: "enum", Query: "color=blue", Status: http.StatusBadRequest, Result: CR{"error": "color must be one of [red, green]"}},
+	}
+	for i := range cases {
+		cases[i].Method, cases[i].Path = http.MethodGet, "/format"
+	}
+	runCases(t, &Api{}, cases)
+}
@@ -0,0 +1,26 @@
+package features
+
+import "context"
+
+type SliceParams struct {
+	IDs    []int    `apivalidator:"csv,minitems=1,maxitems=3,min=1"`
+	Scores []uint8  `apivalidator:"max=10"`
+	Colors []string `apivalidator:"enum=red|green"`
+	Sizes  []Size   `apivalidator:"csv,oneof=1|2"`
+}
+
+type Slices struct {
+	IDs    []int    `json:"ids"`
+	Scores []int    `json:"scores"`
+	Colors []string `json:"colors"`
+	Sizes  []Size   `json:"sizes"`
+}
+
+// apigen:api {"url": "/slices", "methods": ["GET", "POST"]}
+func (a *Api) Slices(ctx context.Context, in SliceParams) (*Slices, error) {
+	slices := &Slices{IDs: in.IDs, Colors: in.Colors, Sizes: in.Sizes, Scores: []int{}}
+	for _, score := range in.Scores {
+		slices.Scores = append(slices.Scores, int(score))
+	}
+	return slices, nil
+}
@@ -0,0 +1,50 @@
+//go:build !fielderrors
+
+package features
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSlices(t *testing.T) {
+	result := func(ids []int, scores []int, colors []string, sizes []int) CR {
+		return CR{"error": "", "response": CR{"ids": ids, "scores": scores, "colors": colors, "sizes": sizes}}
+	}
+	cases := []Case{
+		{
+			Name:   "csv and repeated params",
+			Query:  "ids=1,2,3&scores=10&scores=0&colors=red&colors=green&sizes=2,1",
+			Status: http.StatusOK,
+			Result: result([]int{1, 2, 3}, []int{10, 0}, []string{"red", "green"}, []int{2, 1}),
+		},
+		{
+			Name:   "only the required items",
+			Query:  "ids=7",
+			Status: http.StatusOK,
+			Result: result([]int{7}, []int{}, []string{}, []int{}),
+		},
+		{Name: "minitems", Query: "", Status: http.StatusBadRequest, Result: CR{"error": "ids must have >= 1 items"}},
+		{Name: "maxitems", Query: "ids=1,2,3,4", Status: http.StatusBadRequest, Result: CR{"error": "ids must have <= 3 items"}},
+		{Name: "item min", Query: "ids=1,0", Status: http.StatusBadRequest, Result: CR{"error": "ids must be >= 1"}},
+		{Name: "item type", Query: "ids=1,x", Status: http.StatusBadRequest, Result: CR{"error": "ids must be int"}},
+		{Name: "item max", Query: "ids=1&scores=3&scores=11", Status: http.StatusBadRequest, Result: CR{"error": "scores must be <= 10"}},
+		{Name: "item enum", Query: "ids=1&colors=red&colors=blue", Status: http.StatusBadRequest, Result: CR{"error": "colors must be one of [red, green]"}},
+		{Name: "item oneof", Query: "ids=1&sizes=1,4", Status: http.StatusBadRequest, Result: CR{"error": "sizes must be one of [1, 2]"}},
+		{
+			Name:        "json arrays",
+			Method:      http.MethodPost,
+			ContentType: "application/json",
+			Body:        `{"ids": [4, 5], "scores": [1], "colors": ["green"], "sizes": [2]}`,
+			Status:      http.StatusOK,
+			Result:      result([]int{4, 5}, []int{1}, []string{"green"}, []int{2}),
+		},
+	}
+	for i := range cases {
+		if cases[i].Method == "" {
+			cases[i].Method = http.MethodGet
+		}
+		cases[i].Path = "/slices"
+	}
+	runCases(t, &Api{}, cases)
+}
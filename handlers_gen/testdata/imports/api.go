@@ -0,0 +1,36 @@
+// Package imports refers to packages whose names collide with a standard
+// package and with each other, or differ from their path, see TestImportAliases.
+package imports
+
+import (
+	"context"
+
+	"codegenhw/handlers_gen/testdata/imports/errors"
+	othererrors "codegenhw/handlers_gen/testdata/imports/other/errors"
+	"codegenhw/handlers_gen/testdata/imports/v2"
+)
+
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+type Api struct{}
+
+// apigen:api {"url": "/create", "method": "POST"}
+func (a *Api) Create(ctx context.Context, in errors.Params) (*othererrors.Result, error) {
+	return &othererrors.Result{Name: in.Name}, nil
+}
+
+type Info struct {
+	Version versioned.Tag
+}
+
+// apigen:api {"url": "/info", "method": "GET"}
+func (a *Api) Info(ctx context.Context) (*Info, error) {
+	return &Info{Version: "v2"}, nil
+}
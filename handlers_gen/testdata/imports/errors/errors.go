@@ -0,0 +1,6 @@
+// Package errors has the name of a standard package the generated code imports.
+package errors
+
+type Params struct {
+	Name string `apivalidator:"required"`
+}
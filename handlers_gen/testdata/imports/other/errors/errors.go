@@ -0,0 +1,6 @@
+// Package errors has the same name as the errors package next to it.
+package errors
+
+type Result struct {
+	Name string
+}
@@ -0,0 +1,4 @@
+// Package versioned is named unlike the last element of its path.
+package versioned
+
+type Tag string
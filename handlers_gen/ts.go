@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// TSData is passed to tsTpl to render the TypeScript module.
+type TSData struct {
+	Interfaces []string
+	Clients    []TSApi
+	AuthHeader string
+}
+
+// TSApi is the client class of a single API struct.
+type TSApi struct {
+	Name    string
+	Methods []TSMethod
+}
+
+// TSMethod is a client method calling one API method.
+type TSMethod struct {
+	Name       string
+	HTTPMethod string
+	Url        string
+	// ParamsType is empty if the method takes no params, ResultType is null
+	// if it returns only an error
+	ParamsType string
+	ResultType string
+	Auth       bool
+	InBody     bool
+	InPath     bool
+	// Encode are the statements putting the params fields into the url
+	// (path) and the URLSearchParams (query) of the request
+	Encode []string
+}
+
+var tsTpl = template.Must(template.New("tsTpl").Parse(`// Code generated by handlers_gen. DO NOT EDIT.
+
+/** FieldError is the error of a single invalid param. */
+export interface FieldError {
+  param: string;
+  rule: string;
+  message: string;
+}
+
+/** ApiError is thrown when the API responds with an error. */
+export class ApiError extends Error {
+  readonly status: number;
+  readonly fields: FieldError[];
+
+  constructor(status: number, message: string, fields: FieldError[] = []) {
+    super(message);
+    this.name = "ApiError";
+    this.status = status;
+    this.fields = fields;
+  }
+}
+
+interface Envelope<T> {
+  error: string;
+  response: T;
+  fields?: FieldError[];
+}
+
+async function call<T>(url: string, init: RequestInit): Promise<T> {
+  const resp = await fetch(url, init);
+  let envelope: Envelope<T>;
+  try {
+    envelope = await resp.json();
+  } catch (e) {
+    throw new ApiError(resp.status, "bad response: " + e);
+  }
+  if (envelope.error !== "" || !resp.ok) {
+    throw new ApiError(resp.status, envelope.error, envelope.fields);
+  }
+  return envelope.response;
+}
+{{range .Interfaces}}
+{{.}}
+{{end}}{{range $api := .Clients}}
+/** {{.Name}}Client calls the methods of {{.Name}} over HTTP. */
+export class {{.Name}}Client {
+  readonly baseUrl: string;
+  authToken: string;
+
+  constructor(baseUrl: string, authToken: string = "") {
+    this.baseUrl = baseUrl.replace(/\/$/, "");
+    this.authToken = authToken;
+  }
+{{range .Methods}}
+  async {{.Name}}({{if .ParamsType}}params: {{.ParamsType}}, {{end}}init: RequestInit = {}): Promise<{{.ResultType}}> {
+    {{if .InPath}}let{{else}}const{{end}} path = "{{.Url}}";
+    const query = new URLSearchParams();
+{{range .Encode}}    {{.}}
+{{end}}    const headers = new Headers(init.headers);
+{{if .Auth}}    headers.set("{{$.AuthHeader}}", this.authToken);
+{{end}}{{if .InBody}}    headers.set("Content-Type", "application/x-www-form-urlencoded");
+    return call<{{.ResultType}}>(this.baseUrl + path, { ...init, method: "{{.HTTPMethod}}", headers, body: query });
+{{else}}    const search = query.toString();
+    return call<{{.ResultType}}>(this.baseUrl + path + (search ? "?" + search : ""), { ...init, method: "{{.HTTPMethod}}", headers });
+{{end}}  }
+{{end}}}
+{{end}}`))
+
+// renderTS renders a TypeScript module with the interfaces of the params and
+// result types and a fetch based client class for every API struct.
+func renderTS(data PackageData, mapData map[string][]FuncData, output string) (Output, error) {
+	ts := &tsTypes{pkg: data.Package, json: newJSONTypes(), declared: make(map[string]string)}
+	tsData := TSData{AuthHeader: *authHeader}
+	for _, recvName := range sortedKeys(mapData) {
+		api := TSApi{Name: recvName}
+		httpMethods := operationMethods(mapData[recvName])
+		for _, funcData := range mapData[recvName] {
+			api.Methods = append(api.Methods, ts.method(funcData, httpMethods[funcData.MethodName][0]))
+		}
+		tsData.Clients = append(tsData.Clients, api)
+	}
+	for _, name := range ts.json.sortedNames() {
+		if _, ok := ts.declared[name]; !ok {
+			ts.declared[name] = "export interface " + name + " " + ts.jsonString(ts.json.structs[name])
+		}
+	}
+	names := make([]string, 0, len(ts.declared))
+	for name := range ts.declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tsData.Interfaces = append(tsData.Interfaces, ts.declared[name])
+	}
+
+	body := new(bytes.Buffer)
+	if err := tsTpl.Execute(body, tsData); err != nil {
+		return Output{}, err
+	}
+	return Output{Path: output, Content: body.Bytes(), Kind: "TypeScript client"}, nil
+}
+
+// tsTypes declares the TypeScript interfaces of the package types used by the API.
+type tsTypes struct {
+	pkg      *types.Package
+	json     *jsonTypes
+	declared map[string]string
+}
+
+func (ts *tsTypes) method(funcData FuncData, httpMethod string) TSMethod {
+	paramsType, paramsStruct := paramsOf(funcData)
+
+	fields := paramFields(paramsStruct, ts.pkg)
+	method := TSMethod{
+		Name:       strings.ToLower(funcData.MethodName[:1]) + funcData.MethodName[1:],
+		HTTPMethod: httpMethod,
+		Url:        funcData.Api.Url,
+		ResultType: "null",
+		Auth:       funcData.Api.Auth,
+		InBody:     paramsInBody(httpMethod),
+		InPath:     len(pathPlaceholders(funcData.Api.Url)) > 0,
+	}
+	if paramsType != nil {
+		method.ParamsType = ts.paramsInterface(paramsType, fields)
+	}
+	if result := resultOf(funcData); result != nil {
+		method.ResultType = ts.typeString(result)
+	}
+	for _, field := range fields {
+		method.Encode = append(method.Encode, tsEncodeField(field))
+	}
+	return method
+}
+
+// paramsInterface declares the params struct with the parameter names of the
+// API, a field is optional unless it is required (outside of an optional
+// pointer struct) or taken from the path.
+func (ts *tsTypes) paramsInterface(paramsType types.Type, fields []ParamField) string {
+	name := "Params"
+	if named, ok := paramsType.(*types.Named); ok {
+		name = named.Obj().Name()
+	}
+	if _, ok := ts.declared[name]; ok {
+		return name
+	}
+
+	decl := "export interface " + name + " {\n"
+	for _, field := range fields {
+		args := parseValidatorArgs(field.Tag)
+		optional := "?"
+		if args.Required && field.requiredIf() == "" || args.Source == "path" {
+			optional = ""
+		}
+		decl += fmt.Sprintf("  %s%s: %s;\n", tsPropertyName(paramName(field, args)), optional, tsParamType(field, args))
+	}
+	ts.declared[name] = decl + "}"
+	return name
+}
+
+// tsParamType is the type of a params field, enum and oneof become unions of their values.
+func tsParamType(field ParamField, args ValidatorArgs) string {
+	fieldType := field.Type()
+	suffix := ""
+	if slice, ok := fieldType.Underlying().(*types.Slice); ok {
+		fieldType, suffix = slice.Elem(), "[]"
+	}
+	basic := fieldBasic(fieldType, field.Name())
+	options := args.OneOf
+	if args.HasEnum {
+		options = args.Enum.Values
+	}
+	if len(options) == 0 {
+		return tsBasic(basic) + suffix
+	}
+
+	values := make([]string, 0, len(options))
+	for _, value := range options {
+		if basic.Info()&types.IsString != 0 {
+			value = strconv.Quote(value)
+		}
+		values = append(values, value)
+	}
+	if suffix != "" {
+		return "(" + strings.Join(values, " | ") + ")" + suffix
+	}
+	return strings.Join(values, " | ")
+}
+
+// tsEncodeField returns the statement putting a params field into the request.
+func tsEncodeField(field ParamField) string {
+	args := parseValidatorArgs(field.Tag)
+	name := paramName(field, args)
+	value := "params" + tsAccessor(name)
+
+	if _, ok := field.Type().Underlying().(*types.Slice); ok {
+		if args.Csv {
+			return fmt.Sprintf("if (%s?.length) query.set(%q, %s.join(\",\"));", value, name, value)
+		}
+		return fmt.Sprintf("for (const item of %s ?? []) query.append(%q, String(item));", value, name)
+	}
+	if args.Source == "path" {
+		return fmt.Sprintf("path = path.replace(%q, encodeURIComponent(String(%s)));", "{"+name+"}", value)
+	}
+	return fmt.Sprintf("if (%s !== undefined) query.set(%q, String(%s));", value, name, value)
+}
+
+// typeString describes a result type the way encoding/json marshals it.
+// Named structs are declared as interfaces by renderTS and referenced by name.
+func (ts *tsTypes) typeString(t types.Type) string {
+	return ts.jsonString(ts.json.convert(t))
+}
+
+func (ts *tsTypes) jsonString(t *JSONType) string {
+	switch t.Kind {
+	case JSONPointer:
+		return ts.jsonString(t.Elem) + " | null"
+	case JSONTime, JSONBytes:
+		return "string"
+	case JSONRef:
+		return t.Name
+	case JSONBasic:
+		return tsBasic(t.Basic)
+	case JSONSlice:
+		return "Array<" + ts.jsonString(t.Elem) + "> | null"
+	case JSONArray:
+		return "Array<" + ts.jsonString(t.Elem) + ">"
+	case JSONMap:
+		return "Record<string, " + ts.jsonString(t.Elem) + "> | null"
+	case JSONStruct:
+		decl := "{\n"
+		for _, field := range t.Fields {
+			optional := ""
+			if field.OmitEmpty {
+				optional = "?"
+			}
+			decl += fmt.Sprintf("  %s%s: %s;\n", tsPropertyName(field.Name), optional, ts.jsonString(field.Type))
+		}
+		return decl + "}"
+	}
+	return "unknown"
+}
+
+func tsBasic(basic *types.Basic) string {
+	switch {
+	case basic.Info()&types.IsBoolean != 0:
+		return "boolean"
+	case basic.Info()&types.IsNumeric != 0:
+		return "number"
+	case basic.Info()&types.IsString != 0:
+		return "string"
+	}
+	return "unknown"
+}
+
+// tsPropertyName quotes names which are not valid identifiers, e.g. full-name.
+func tsPropertyName(name string) string {
+	if tsIdentifier(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func tsAccessor(name string) string {
+	if tsIdentifier(name) {
+		return "." + name
+	}
+	return "[" + strconv.Quote(name) + "]"
+}
+
+func tsIdentifier(name string) bool {
+	for i, char := range name {
+		isLetter := char == '_' || char == '$' || char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z'
+		if !isLetter && (i == 0 || char < '0' || char > '9') {
+			return false
+		}
+	}
+	return name != ""
+}
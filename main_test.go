@@ -136,7 +136,7 @@ func TestMyApi(t *testing.T) {
 			Path:   ApiUserCreate,
 			Method: http.MethodGet,
 			Query:  "login=mr.moderator&age=32&status=moderator&full_name=GetMethod",
-			Status: http.StatusNotAcceptable,
+			Status: http.StatusMethodNotAllowed,
 			Auth:   true,
 			Result: CR{
 				"error": "bad method",